@@ -2,6 +2,7 @@ package backends
 
 import (
 	"github.com/aluka-7/configuration/backends/mock"
+	"github.com/aluka-7/configuration/backends/types"
 	"github.com/aluka-7/configuration/backends/zookeeper"
 	"github.com/samuel/go-zookeeper/zk"
 )
@@ -15,20 +16,54 @@ type StoreConfig struct {
 	Exp          map[string]string `json:"exp"`
 }
 
+// Node creation flags accepted by StoreClient.Add.
+const (
+	FlagEphemeral = types.FlagEphemeral
+	FlagSequence  = types.FlagSequence
+)
+
+// Stat is the metadata of a stored node, see types.Stat.
+type Stat = types.Stat
+
+// Event is a one-shot watch notification, see types.Event.
+type Event = types.Event
+
+// Locker is a distributed mutual exclusion lock, see types.Locker.
+type Locker = types.Locker
+
+var (
+	ErrNoNode     = types.ErrNoNode
+	ErrNodeExists = types.ErrNodeExists
+	ErrDeadlock   = types.ErrDeadlock
+	ErrNotLocked  = types.ErrNotLocked
+	ErrLockLost   = types.ErrLockLost
+)
+
 // The StoreClient interface is implemented by objects that can retrieve key/value pairs from a backend store.
 type StoreClient interface {
 	Client() *zk.Conn
+	// GetValues returns the values of the given nodes, nodes that do not exist are omitted from the result.
 	GetValues(keys []string) (map[string]string, error)
+	Get(path string) ([]byte, *Stat, error)
 	WatchPrefix(keys []string, waitIndex uint64, stopChan chan bool) (uint64, error)
-	Lock(path string) *zk.Lock
+	Lock(path string) Locker
 	Add(path string, value []byte, flags int32) (string, error)
 	Modify(path string, value []byte) error
 	Delete(path string) error
+	Exists(path string) (bool, error)
+	ExistsW(path string) (bool, <-chan Event, error)
+	Children(path string) ([]string, error)
+	ChildrenW(path string) ([]string, <-chan Event, error)
+	Close()
 }
 
 // New is used to create a storage client based on our configuration.
 func New(conf StoreConfig) (StoreClient, error) {
-	return zookeeper.NewZookeeperClient([]string{conf.Backend}, conf.Username, conf.Password, conf.OpenUser, conf.OpenPassword)
+	c, err := zookeeper.NewZookeeperClient([]string{conf.Backend}, conf.Username, conf.Password, conf.OpenUser, conf.OpenPassword)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
 }
 func NewMock(conf StoreConfig) (StoreClient, error) {
 	return mock.NewMockClient(conf.Exp)
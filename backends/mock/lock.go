@@ -0,0 +1,135 @@
+package mock
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aluka-7/configuration/backends/types"
+)
+
+// Lock is an in-memory mutual exclusion lock shared by all sessions of the same mock store.
+type Lock struct {
+	client  *Client
+	path    string
+	mu      sync.Mutex
+	pending bool
+	held    chan struct{}
+	lost    chan struct{}
+}
+
+func (l *Lock) Lock() error {
+	return l.LockContext(context.Background())
+}
+
+func (l *Lock) LockContext(ctx context.Context) error {
+	token, err := l.begin()
+	if err != nil {
+		return err
+	}
+	select {
+	case token <- struct{}{}:
+		return l.acquired(token)
+	case <-ctx.Done():
+		l.end()
+		return ctx.Err()
+	}
+}
+
+func (l *Lock) TryLock() (bool, error) {
+	token, err := l.begin()
+	if err != nil {
+		return false, err
+	}
+	select {
+	case token <- struct{}{}:
+		return true, l.acquired(token)
+	default:
+		l.end()
+		return false, nil
+	}
+}
+
+func (l *Lock) Unlock() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held == nil {
+		return types.ErrNotLocked
+	}
+	token := l.held
+	l.held = nil
+	select {
+	case <-l.lost:
+		return types.ErrLockLost
+	default:
+	}
+	l.client.mu.Lock()
+	delete(l.client.held, l)
+	l.client.mu.Unlock()
+	<-token
+	return nil
+}
+
+func (l *Lock) Lost() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lost
+}
+
+// begin marks the lock as being acquired and returns the token channel of its path.
+func (l *Lock) begin() (chan struct{}, error) {
+	if l.client.isClosed() {
+		return nil, types.ErrClosed
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held != nil || l.pending {
+		return nil, types.ErrDeadlock
+	}
+	t := l.client.tree
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	token, ok := t.locks[l.path]
+	if !ok {
+		token = make(chan struct{}, 1)
+		t.locks[l.path] = token
+	}
+	l.pending = true
+	return token, nil
+}
+
+func (l *Lock) end() {
+	l.mu.Lock()
+	l.pending = false
+	l.mu.Unlock()
+}
+
+// acquired registers the lock with its session so that closing the session releases it.
+func (l *Lock) acquired(token chan struct{}) error {
+	l.mu.Lock()
+	l.pending = false
+	l.held = token
+	l.lost = make(chan struct{})
+	l.mu.Unlock()
+	l.client.mu.Lock()
+	closed := l.client.closed
+	if !closed {
+		l.client.held[l] = struct{}{}
+	}
+	l.client.mu.Unlock()
+	if closed {
+		l.expire()
+		return types.ErrClosed
+	}
+	return nil
+}
+
+// expire releases the lock on behalf of a closed session and notifies the holder.
+func (l *Lock) expire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held == nil {
+		return
+	}
+	close(l.lost)
+	<-l.held
+}
@@ -1,45 +1,326 @@
 package mock
 
 import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aluka-7/configuration/backends/types"
 	"github.com/samuel/go-zookeeper/zk"
 )
 
+// NewMockClient creates an in-memory store client seeded with the given path/value pairs.
 func NewMockClient(store map[string]string) (*Client, error) {
-	return &Client{store: store}, nil
+	t := &tree{
+		nodes:        make(map[string]*node, len(store)),
+		seq:          make(map[string]int64),
+		locks:        make(map[string]chan struct{}),
+		watches:      make(map[string][]chan types.Event),
+		childWatches: make(map[string][]chan types.Event),
+	}
+	now := time.Now()
+	for k, v := range store {
+		t.nodes[k] = &node{value: []byte(v), stat: types.Stat{Ctime: now, Mtime: now}}
+	}
+	return newClient(t), nil
+}
+
+// tree is the in-memory data shared by all sessions of a mock store.
+type tree struct {
+	mu           sync.Mutex
+	nodes        map[string]*node
+	seq          map[string]int64
+	locks        map[string]chan struct{}
+	watches      map[string][]chan types.Event
+	childWatches map[string][]chan types.Event
+}
+
+// exists reports whether p is a node or the implicit parent of one.
+func (t *tree) exists(p string) bool {
+	if _, ok := t.nodes[p]; ok {
+		return true
+	}
+	prefix := childPrefix(p)
+	for k := range t.nodes {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *tree) children(p string) []string {
+	prefix := childPrefix(p)
+	seen := make(map[string]bool)
+	children := make([]string, 0)
+	for k := range t.nodes {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		name := strings.SplitN(k[len(prefix):], "/", 2)[0]
+		if !seen[name] {
+			seen[name] = true
+			children = append(children, name)
+		}
+	}
+	sort.Strings(children)
+	return children
+}
+
+// put stores the node and fires the watches of the node and its parent.
+func (t *tree) put(p string, n *node) {
+	old, existed := t.nodes[p]
+	n.stat.Mtime = time.Now()
+	if existed {
+		n.stat.Ctime, n.stat.Version = old.stat.Ctime, old.stat.Version+1
+	} else {
+		n.stat.Ctime = n.stat.Mtime
+	}
+	t.nodes[p] = n
+	if existed {
+		t.fire(t.watches, p, types.EventNodeDataChanged)
+		return
+	}
+	t.fire(t.watches, p, types.EventNodeCreated)
+	t.fire(t.childWatches, parentPath(p), types.EventNodeChildrenChanged)
+}
+
+// remove deletes the node and fires the watches of the node and its parent.
+func (t *tree) remove(p string) {
+	delete(t.nodes, p)
+	t.fire(t.watches, p, types.EventNodeDeleted)
+	t.fire(t.childWatches, p, types.EventNodeDeleted)
+	t.fire(t.childWatches, parentPath(p), types.EventNodeChildrenChanged)
 }
 
+func (t *tree) watch(watches map[string][]chan types.Event, p string) <-chan types.Event {
+	ch := make(chan types.Event, 1)
+	watches[p] = append(watches[p], ch)
+	return ch
+}
+
+// fire delivers a one-shot event to every watch set on p, like zookeeper watches are cleared once triggered.
+func (t *tree) fire(watches map[string][]chan types.Event, p string, typ types.EventType) {
+	for _, ch := range watches[p] {
+		ch <- types.Event{Type: typ, Path: p}
+	}
+	delete(watches, p)
+}
+
+type node struct {
+	value []byte
+	owner *Client // 临时节点所属的会话，持久节点为nil
+	stat  types.Stat
+}
+
+// Client is a session on an in-memory store, ephemeral nodes and locks are bound to the session that created them.
 type Client struct {
-	store map[string]string
+	tree   *tree
+	mu     sync.Mutex
+	closed bool
+	held   map[*Lock]struct{}
 }
 
-func (c Client) Client() *zk.Conn {
-	return nil
+func newClient(t *tree) *Client {
+	return &Client{tree: t, held: make(map[*Lock]struct{})}
+}
+
+// NewSession opens another session on the same in-memory data, like a second process connected to the same backend.
+func (c *Client) NewSession() *Client {
+	return newClient(c.tree)
 }
 
-func (c Client) Lock(path string) *zk.Lock {
+func (c *Client) Client() *zk.Conn {
 	return nil
 }
 
-func (c Client) Delete(path string) error {
+// Close ends the session: its ephemeral nodes are removed and the locks it holds are lost.
+func (c *Client) Close() {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	held := c.held
+	c.held = nil
+	c.mu.Unlock()
+	for l := range held {
+		l.expire()
+	}
+	c.tree.mu.Lock()
+	defer c.tree.mu.Unlock()
+	for p, n := range c.tree.nodes {
+		if n.owner == c {
+			c.tree.remove(p)
+		}
+	}
+}
+
+func (c *Client) Lock(path string) types.Locker {
+	return &Lock{client: c, path: path}
+}
+
+func (c *Client) Delete(path string) error {
+	if c.isClosed() {
+		return types.ErrClosed
+	}
+	c.tree.mu.Lock()
+	defer c.tree.mu.Unlock()
+	if _, ok := c.tree.nodes[path]; !ok {
+		return types.ErrNoNode
+	}
+	c.tree.remove(path)
 	return nil
 }
 
-func (c Client) Add(path string, value []byte, flags int32) (string, error) {
-	return "", nil
+func (c *Client) Add(path string, value []byte, flags int32) (string, error) {
+	if c.isClosed() {
+		return "", types.ErrClosed
+	}
+	c.tree.mu.Lock()
+	defer c.tree.mu.Unlock()
+	// like zookeeper, the parent must exist; seeded paths have implicit parents
+	if parent := parentPath(path); parent != "/" && !c.tree.exists(parent) {
+		return "", types.ErrNoNode
+	}
+	if flags&types.FlagSequence != 0 {
+		parent := path[:strings.LastIndex(path, "/")+1]
+		c.tree.seq[parent]++
+		path = fmt.Sprintf("%s%010d", path, c.tree.seq[parent])
+	}
+	if _, ok := c.tree.nodes[path]; ok {
+		return "", types.ErrNodeExists
+	}
+	n := &node{value: value}
+	if flags&types.FlagEphemeral != 0 {
+		n.owner = c
+	}
+	c.tree.put(path, n)
+	return path, nil
 }
 
-func (c Client) Modify(path string, value []byte) error {
+func (c *Client) Modify(path string, value []byte) error {
+	if c.isClosed() {
+		return types.ErrClosed
+	}
+	c.tree.mu.Lock()
+	defer c.tree.mu.Unlock()
+	n, ok := c.tree.nodes[path]
+	if !ok {
+		return types.ErrNoNode
+	}
+	c.tree.put(path, &node{value: value, owner: n.owner})
 	return nil
 }
 
-func (c Client) GetValues(keys []string) (vls map[string]string, err error) {
+func (c *Client) GetValues(keys []string) (vls map[string]string, err error) {
+	c.tree.mu.Lock()
+	defer c.tree.mu.Unlock()
 	vls = make(map[string]string, len(keys))
 	for _, v := range keys {
-		vls[v] = c.store[v]
+		if n, ok := c.tree.nodes[v]; ok {
+			vls[v] = string(n.value)
+		}
 	}
 	return
 }
 
-func (c Client) WatchPrefix(keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
-	return 0, nil
+func (c *Client) Get(path string) ([]byte, *types.Stat, error) {
+	c.tree.mu.Lock()
+	defer c.tree.mu.Unlock()
+	n, ok := c.tree.nodes[path]
+	if !ok {
+		return nil, nil, types.ErrNoNode
+	}
+	stat := n.stat
+	return n.value, &stat, nil
+}
+
+func (c *Client) Exists(path string) (bool, error) {
+	c.tree.mu.Lock()
+	defer c.tree.mu.Unlock()
+	return c.tree.exists(path), nil
+}
+
+func (c *Client) ExistsW(path string) (bool, <-chan types.Event, error) {
+	c.tree.mu.Lock()
+	defer c.tree.mu.Unlock()
+	return c.tree.exists(path), c.tree.watch(c.tree.watches, path), nil
+}
+
+func (c *Client) Children(path string) ([]string, error) {
+	c.tree.mu.Lock()
+	defer c.tree.mu.Unlock()
+	if !c.tree.exists(path) {
+		return nil, types.ErrNoNode
+	}
+	return c.tree.children(path), nil
+}
+
+func (c *Client) ChildrenW(path string) ([]string, <-chan types.Event, error) {
+	c.tree.mu.Lock()
+	defer c.tree.mu.Unlock()
+	if !c.tree.exists(path) {
+		return nil, nil, types.ErrNoNode
+	}
+	return c.tree.children(path), c.tree.watch(c.tree.childWatches, path), nil
+}
+
+// WatchPrefix blocks until the data or children of one of the keys change or stopChan is closed,
+// a zero waitIndex returns immediately to trigger the initial retrieval like the zookeeper client.
+func (c *Client) WatchPrefix(keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	if waitIndex == 0 {
+		return 1, nil
+	}
+	if c.isClosed() {
+		return 0, types.ErrClosed
+	}
+	c.tree.mu.Lock()
+	cases := make([]reflect.SelectCase, 0, 2*len(keys)+1)
+	cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(stopChan)})
+	for _, k := range keys {
+		for _, ch := range []<-chan types.Event{c.tree.watch(c.tree.watches, k), c.tree.watch(c.tree.childWatches, k)} {
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch)})
+		}
+	}
+	c.tree.mu.Unlock()
+	if i, _, _ := reflect.Select(cases); i == 0 {
+		return waitIndex, nil
+	}
+	return waitIndex + 1, nil
+}
+
+// PendingWatches returns the number of watches set on the store that have not fired yet.
+func (c *Client) PendingWatches() int {
+	c.tree.mu.Lock()
+	defer c.tree.mu.Unlock()
+	n := 0
+	for _, chs := range c.tree.watches {
+		n += len(chs)
+	}
+	for _, chs := range c.tree.childWatches {
+		n += len(chs)
+	}
+	return n
+}
+
+func childPrefix(p string) string {
+	return strings.TrimSuffix(p, "/") + "/"
+}
+
+func parentPath(p string) string {
+	if i := strings.LastIndex(p, "/"); i > 0 {
+		return p[:i]
+	}
+	return "/"
+}
+
+func (c *Client) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
 }
@@ -0,0 +1,77 @@
+// Package types holds the backend-neutral types shared by the store clients and the backends package.
+package types
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// Node creation flags accepted by StoreClient.Add, compatible with the zookeeper flags.
+const (
+	FlagEphemeral = zk.FlagEphemeral
+	FlagSequence  = zk.FlagSequence
+)
+
+// Stat is the metadata of a stored node.
+type Stat struct {
+	Version int32     // data version, incremented on every modification
+	Ctime   time.Time // creation time, assigned by the backend server
+	Mtime   time.Time // last modification time, assigned by the backend server
+}
+
+// Event is a one-shot watch notification, compatible with the zookeeper watch events.
+type Event = zk.Event
+
+// EventType is the kind of change reported by an Event.
+type EventType = zk.EventType
+
+const (
+	EventNodeCreated         = zk.EventNodeCreated
+	EventNodeDeleted         = zk.EventNodeDeleted
+	EventNodeDataChanged     = zk.EventNodeDataChanged
+	EventNodeChildrenChanged = zk.EventNodeChildrenChanged
+	EventNotWatching         = zk.EventNotWatching
+)
+
+var (
+	// ErrNoNode is returned when the requested node does not exist.
+	ErrNoNode = zk.ErrNoNode
+	// ErrNodeExists is returned when creating a node that already exists.
+	ErrNodeExists = zk.ErrNodeExists
+	// ErrDeadlock is returned by Lock when trying to lock twice without unlocking first.
+	ErrDeadlock = zk.ErrDeadlock
+	// ErrNotLocked is returned by Unlock when trying to release a lock that has not first been acquired.
+	ErrNotLocked = zk.ErrNotLocked
+	// ErrLockLost is returned by Unlock when the lock was lost before it was released, e.g. the session expired.
+	ErrLockLost = errors.New("lock lost")
+	// ErrClosed is returned when using a store client whose session has been closed.
+	ErrClosed = errors.New("store client closed")
+)
+
+// Locker is a distributed mutual exclusion lock. A Locker starts unlocked and is not reentrant:
+// locking it twice without unlocking returns ErrDeadlock.
+type Locker interface {
+	// Lock blocks until the lock is acquired or an error occurs.
+	Lock() error
+	// LockContext blocks until the lock is acquired, an error occurs or ctx is done.
+	LockContext(ctx context.Context) error
+	// TryLock acquires the lock only if it is free at the time of the call.
+	TryLock() (bool, error)
+	// Unlock releases the lock.
+	Unlock() error
+	// Lost returns a channel that is closed when the held lock is lost without being unlocked,
+	// typically because the backend session expired. A new channel is used for every acquisition,
+	// before the first acquisition it is nil.
+	Lost() <-chan struct{}
+}
+
+// ParseSeq extracts the sequence number appended to a sequential node name.
+func ParseSeq(name string) (int64, error) {
+	parts := strings.Split(name, "-")
+	return strconv.ParseInt(parts[len(parts)-1], 10, 64)
+}
@@ -3,6 +3,7 @@ package zookeeper
 import (
 	"time"
 
+	"github.com/aluka-7/configuration/backends/types"
 	"github.com/rs/zerolog/log"
 	"github.com/samuel/go-zookeeper/zk"
 )
@@ -19,10 +20,12 @@ type watchResponse struct {
 	err       error
 }
 
+// NewZookeeperClient connects to the given servers, the connection is established and re-established in the background.
+// An error is returned when the server list is invalid, e.g. the hosts cannot be resolved.
 func NewZookeeperClient(machines []string, user, password, openUser, openPassword string) (*Client, error) {
 	c, _, err := zk.Connect(machines, DefaultSessionTimeout)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	if err := c.AddAuth("digest", []byte(user+":"+password)); err != nil {
 		log.Err(err).Msg("AddAuth user returned error")
@@ -39,8 +42,13 @@ func (c *Client) Client() *zk.Conn {
 	return c.client
 }
 
-func (c *Client) Lock(path string) *zk.Lock {
-	return zk.NewLock(c.client, path, zk.WorldACL(zk.PermAll))
+func (c *Client) Lock(path string) types.Locker {
+	return NewLock(c.client, path)
+}
+
+// Close closes the zookeeper session, ephemeral nodes and locks held by this client are released.
+func (c *Client) Close() {
+	c.client.Close()
 }
 
 func (c *Client) Add(path string, value []byte, flags int32) (string, error) {
@@ -53,25 +61,61 @@ func (c *Client) Add(path string, value []byte, flags int32) (string, error) {
 }
 
 func (c *Client) Modify(path string, value []byte) error {
-	_, stat, _ := c.client.Get(path)
-	_, err := c.client.Set(path, value, stat.Version)
+	_, stat, err := c.client.Get(path)
+	if err != nil {
+		return err
+	}
+	_, err = c.client.Set(path, value, stat.Version)
 	return err
 }
 
 func (c *Client) Delete(path string) error {
-	_, stat, _ := c.client.Get(path)
-	err := c.client.Delete(path, stat.Version)
-	return err
+	_, stat, err := c.client.Get(path)
+	if err != nil {
+		return err
+	}
+	return c.client.Delete(path, stat.Version)
 }
 
+// Get returns the data of the node together with its metadata.
+func (c *Client) Get(path string) ([]byte, *types.Stat, error) {
+	b, stat, err := c.client.Get(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b, &types.Stat{Version: stat.Version, Ctime: time.UnixMilli(stat.Ctime), Mtime: time.UnixMilli(stat.Mtime)}, nil
+}
+
+func (c *Client) Exists(path string) (bool, error) {
+	exists, _, err := c.client.Exists(path)
+	return exists, err
+}
+
+// ExistsW reports whether the node exists and sets a watch that fires once when it is created, changed or deleted.
+func (c *Client) ExistsW(path string) (bool, <-chan types.Event, error) {
+	exists, _, ch, err := c.client.ExistsW(path)
+	return exists, ch, err
+}
+
+func (c *Client) Children(path string) ([]string, error) {
+	children, _, err := c.client.Children(path)
+	return children, err
+}
+
+// ChildrenW lists the children of the node and sets a watch that fires once when they change or the node is deleted.
+func (c *Client) ChildrenW(path string) ([]string, <-chan types.Event, error) {
+	children, _, ch, err := c.client.ChildrenW(path)
+	return children, ch, err
+}
+
+// GetValues reads every key with a single Get, keys that do not exist are left out of the result.
+// There is no Exists pre-check: it doubled the round trips and a node deleted between the two calls is reported as ErrNoNode anyway.
 func (c *Client) GetValues(keys []string) (map[string]string, error) {
 	vars := make(map[string]string)
 	for _, v := range keys {
-		_, _, err := c.client.Exists(v)
-		if err != nil {
-			return vars, err
-		}
-		if b, _, err := c.client.Get(v); err != nil {
+		if b, _, err := c.client.Get(v); err == zk.ErrNoNode {
+			continue
+		} else if err != nil {
 			return vars, err
 		} else {
 			vars[v] = string(b)
@@ -86,7 +130,8 @@ func (c *Client) WatchPrefix(keys []string, waitIndex uint64, stopChan chan bool
 		return 1, nil
 	}
 
-	respChan := make(chan watchResponse)
+	// every watch sends at most two responses, buffer them so that watches finishing after return do not block
+	respChan := make(chan watchResponse, 2*len(keys))
 	cancelRoutine := make(chan bool)
 	defer close(cancelRoutine)
 
@@ -94,7 +139,6 @@ func (c *Client) WatchPrefix(keys []string, waitIndex uint64, stopChan chan bool
 	for _, v := range keys {
 		log.Info().Msgf("Watching:%s", v)
 		go c.watch(v, respChan, cancelRoutine)
-		break
 	}
 
 	for {
@@ -107,23 +151,35 @@ func (c *Client) WatchPrefix(keys []string, waitIndex uint64, stopChan chan bool
 	}
 }
 
+// watch reports a change of the data or children of key, a missing key is watched until it is created.
 func (c *Client) watch(key string, respChan chan watchResponse, cancelRoutine chan bool) {
 	_, _, keyEventCh, err := c.client.GetW(key)
+	if err == zk.ErrNoNode {
+		var exists bool
+		if exists, _, keyEventCh, err = c.client.ExistsW(key); err == nil && exists {
+			// created after GetW
+			respChan <- watchResponse{1, nil}
+			return
+		}
+	}
 	if err != nil {
 		respChan <- watchResponse{0, err}
+		return
 	}
 	_, _, childEventCh, err := c.client.ChildrenW(key)
-	if err != nil {
+	if err != nil && err != zk.ErrNoNode {
 		respChan <- watchResponse{0, err}
+		return
 	}
 	select {
 	case <-cancelRoutine:
 		log.Info().Msgf("Stop watching:%s", key)
 		return
 	case e := <-keyEventCh:
-		if e.Type == zk.EventNodeDataChanged {
+		switch e.Type {
+		case zk.EventNodeDataChanged, zk.EventNodeCreated, zk.EventNodeDeleted:
 			respChan <- watchResponse{1, e.Err}
-		} else if e.Type == zk.EventNotWatching {
+		case zk.EventNotWatching:
 			log.Info().Msgf("Not watching:%s", key)
 			respChan <- watchResponse{0, e.Err}
 		}
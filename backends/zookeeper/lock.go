@@ -0,0 +1,204 @@
+package zookeeper
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aluka-7/configuration/backends/types"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// Lock is a mutual exclusion lock built on ephemeral sequential nodes under path, the lowest node holds the lock.
+// Unlike zk.Lock it can be cancelled through a context and reports when the session behind a held lock is gone.
+type Lock struct {
+	c        *zk.Conn
+	path     string
+	acl      []zk.ACL
+	mu       sync.Mutex
+	pending  bool
+	lockPath string
+	unlocked chan struct{}
+	lost     chan struct{}
+}
+
+// NewLock creates a new lock instance using the provided connection and path.
+// The path must be a node that is only used by this lock.
+func NewLock(c *zk.Conn, path string) *Lock {
+	return &Lock{c: c, path: path, acl: zk.WorldACL(zk.PermAll)}
+}
+
+// Lock attempts to acquire the lock, waiting until the lock is acquired or an error occurs.
+func (l *Lock) Lock() error {
+	return l.LockContext(context.Background())
+}
+
+// LockContext attempts to acquire the lock, waiting until the lock is acquired, an error occurs or ctx is done.
+// If this instance already holds the lock then ErrDeadlock is returned.
+func (l *Lock) LockContext(ctx context.Context) error {
+	_, err := l.acquire(ctx, true)
+	return err
+}
+
+// TryLock attempts to acquire the lock without waiting for its current holder.
+func (l *Lock) TryLock() (bool, error) {
+	return l.acquire(context.Background(), false)
+}
+
+// Unlock releases the lock. It returns ErrNotLocked if the lock is not held and ErrLockLost if
+// the lock node disappeared before it was released.
+func (l *Lock) Unlock() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.lockPath == "" {
+		return types.ErrNotLocked
+	}
+	path := l.lockPath
+	l.lockPath = ""
+	close(l.unlocked)
+	select {
+	case <-l.lost:
+		return types.ErrLockLost
+	default:
+	}
+	if err := l.c.Delete(path, -1); err != nil && err != zk.ErrNoNode {
+		return err
+	}
+	return nil
+}
+
+// Lost returns the channel closed when the lock of the current acquisition is lost.
+func (l *Lock) Lost() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lost
+}
+
+func (l *Lock) acquire(ctx context.Context, wait bool) (bool, error) {
+	l.mu.Lock()
+	if l.lockPath != "" || l.pending {
+		l.mu.Unlock()
+		return false, types.ErrDeadlock
+	}
+	l.pending = true
+	l.mu.Unlock()
+
+	path, err := l.wait(ctx, wait)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pending = false
+	if err != nil || path == "" {
+		return false, err
+	}
+	l.lockPath = path
+	l.unlocked = make(chan struct{})
+	l.lost = make(chan struct{})
+	go l.monitor(path, l.unlocked, l.lost)
+	return true, nil
+}
+
+// wait creates the lock node and waits until it is the lowest one, the node is removed again on failure.
+func (l *Lock) wait(ctx context.Context, wait bool) (string, error) {
+	path, err := l.create()
+	if err != nil {
+		return "", err
+	}
+	seq, err := types.ParseSeq(path)
+	if err != nil {
+		l.c.Delete(path, -1)
+		return "", err
+	}
+	for {
+		children, _, err := l.c.Children(l.path)
+		if err != nil {
+			l.c.Delete(path, -1)
+			return "", err
+		}
+		// 查找序号比自己小的最近一个节点，不存在则说明已获得锁
+		prevSeq, prevPath := int64(-1), ""
+		for _, p := range children {
+			if s, err := types.ParseSeq(p); err == nil && s < seq && s > prevSeq {
+				prevSeq, prevPath = s, p
+			}
+		}
+		if prevPath == "" {
+			return path, nil
+		}
+		if !wait {
+			l.c.Delete(path, -1)
+			return "", nil
+		}
+		exists, _, ch, err := l.c.ExistsW(l.path + "/" + prevPath)
+		if err != nil {
+			l.c.Delete(path, -1)
+			return "", err
+		}
+		if !exists {
+			continue
+		}
+		select {
+		case ev := <-ch:
+			if ev.Err != nil {
+				l.c.Delete(path, -1)
+				return "", ev.Err
+			}
+		case <-ctx.Done():
+			l.c.Delete(path, -1)
+			return "", ctx.Err()
+		}
+	}
+}
+
+func (l *Lock) create() (string, error) {
+	prefix := l.path + "/lock-"
+	for i := 0; i < 3; i++ {
+		path, err := l.c.CreateProtectedEphemeralSequential(prefix, []byte{}, l.acl)
+		if err != zk.ErrNoNode {
+			return path, err
+		}
+		// 创建父节点
+		p := ""
+		for _, part := range strings.Split(l.path, "/")[1:] {
+			p += "/" + part
+			if _, err := l.c.Create(p, []byte{}, 0, l.acl); err != nil && err != zk.ErrNodeExists {
+				return "", err
+			}
+		}
+	}
+	return "", zk.ErrNoNode
+}
+
+// monitor watches the held lock node and closes lost when it disappears or the session expires before Unlock.
+func (l *Lock) monitor(path string, unlocked, lost chan struct{}) {
+watch:
+	for {
+		exists, _, ch, err := l.c.ExistsW(path)
+		switch {
+		case err == zk.ErrSessionExpired || err == zk.ErrClosing || err == nil && !exists:
+			break watch
+		case err != nil:
+			// 连接暂时中断时会话仍可能恢复，稍后重试
+			select {
+			case <-unlocked:
+				return
+			case <-time.After(time.Second):
+				continue
+			}
+		}
+		select {
+		case ev := <-ch:
+			if ev.Type == zk.EventNodeDeleted || ev.Type == zk.EventNotWatching {
+				break watch
+			}
+		case <-unlocked:
+			return
+		}
+	}
+	select {
+	case <-unlocked:
+	default:
+		close(lost)
+	}
+}
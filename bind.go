@@ -0,0 +1,137 @@
+package configuration
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Value 绑定到一个配置项的热更新值，Load无锁地返回最新的配置，配置中心的数据变化后自动更新。
+// 变化后的数据解析失败、校验失败或配置项被删除时保留上一次的值，解析和校验失败通过OnReject报告。
+type Value[T any] struct {
+	ptr       atomic.Pointer[T]
+	conf      *configuration
+	path      string
+	layers    []string // 分层查找的候选路径
+	opts      []DecodeOption
+	mu        sync.Mutex
+	listeners []func(old, new T)
+	rejects   []func(err error)
+	processor Processor
+}
+
+// Bind 将指定配置项绑定为类型T的热更新值：先同步加载一次，获取、解析或校验失败时返回错误，之后通过监听自动更新。
+// 加载之前已设置监听，Bind返回后发生的变化都会被更新。
+// string类型直接使用原始的配置数据，其他类型同Clazz按opts、路径后缀或内容特征选择解码器。
+// 开启分层查找时监听所有候选路径，按合并策略使用优先级最高的配置项或合并各层，任意一层变化都会重新计算；
+// 开启变量插值时被引用的配置项变化也会重新计算。
+// 不再使用时调用Close停止监听。
+func Bind[T any](conf Configuration, app, group, tag, path string, opts ...DecodeOption) (*Value[T], error) {
+	c, ok := conf.(*configuration)
+	if !ok {
+		return nil, fmt.Errorf("不支持的配置管理引擎:%T", conf)
+	}
+	layers := c.layers(app, group, tag, path)
+	v := &Value[T]{conf: c, path: layers[0], layers: layers, opts: opts}
+	vl, refs, sentinel, err := c.armedValues([][]string{layers}, v.merged())
+	if err != nil {
+		return nil, err
+	}
+	initial, ok, err := v.decode(vl)
+	if err != nil || !ok {
+		sentinel.fire()
+		if err == nil {
+			err = notFound(layers)
+		}
+		return nil, err
+	}
+	v.ptr.Store(&initial)
+	v.processor = c.watch([][]string{layers}, v.merged(), refs, sentinel, v)
+	return v, nil
+}
+
+// Load 获取当前的配置值。
+func (v *Value[T]) Load() T {
+	return *v.ptr.Load()
+}
+
+// OnChange 注册配置值变化后的回调，回调在监听协程中按注册顺序执行。
+func (v *Value[T]) OnChange(fn func(old, new T)) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.listeners = append(v.listeners, fn)
+}
+
+// OnReject 注册变化后的配置被拒绝(解析或校验失败)时的回调。
+func (v *Value[T]) OnReject(fn func(err error)) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.rejects = append(v.rejects, fn)
+}
+
+// Close 停止监听配置变化，之后Load返回最后一次的值。
+func (v *Value[T]) Close() {
+	v.processor.Stop()
+}
+
+// Changed 实现ChangedListener，解析变化后的数据并替换当前值。
+func (v *Value[T]) Changed(data map[string]string) {
+	n, ok, err := v.decode(data)
+	if err == nil && !ok {
+		log.Info().Msgf("配置项[%s]已被删除，保留上一次的值", v.path)
+		return
+	}
+	if err != nil {
+		log.Err(err).Msgf("配置项[%s]变化后的值被拒绝，保留上一次的值", v.path)
+		v.mu.Lock()
+		rejects := v.rejects
+		v.mu.Unlock()
+		for _, fn := range rejects {
+			fn(err)
+		}
+		return
+	}
+	old := v.ptr.Swap(&n)
+	v.mu.Lock()
+	listeners := v.listeners
+	v.mu.Unlock()
+	for _, fn := range listeners {
+		fn(*old, n)
+	}
+}
+
+// merged 是否合并各层的配置，string类型总是使用优先级最高的原始配置数据，不合并。
+func (v *Value[T]) merged() bool {
+	var zero T
+	if _, raw := any(&zero).(*string); raw {
+		return false
+	}
+	return v.conf.strategy(v.opts) != MergeReplace
+}
+
+// decode 按合并策略从各层的配置数据解码出新值，所有层都不存在时ok为false。string类型总是使用优先级最高的原始配置数据，不合并。
+func (v *Value[T]) decode(data map[string]string) (T, bool, error) {
+	opts := v.opts
+	var zero T
+	if !v.merged() {
+		opts = append(append([]DecodeOption{}, opts...), WithMerge(MergeReplace))
+	}
+	r, opts, ok, err := v.conf.document(v.layers, data, opts)
+	if err != nil || !ok {
+		return zero, ok, err
+	}
+	n, err := decodeValue[T](r.Source, r.Value, opts)
+	return n, true, err
+}
+
+func decodeValue[T any](path, raw string, opts []DecodeOption) (T, error) {
+	var v T
+	if s, ok := any(&v).(*string); ok {
+		*s = raw
+	} else if err := decode(path, []byte(raw), &v, opts); err != nil {
+		return v, err
+	}
+	return v, validateValue(path, &v, opts)
+}
@@ -0,0 +1,214 @@
+package configuration
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aluka-7/configuration/backends"
+)
+
+// WithCache 开启进程内的读缓存：String/Values/Clazz等读取的配置项缓存在内存中，每个缓存的配置项在配置中心设置监听，
+// 配置项被创建、修改或删除时(以及通过本引擎写入时)失效；size为最多缓存的配置项数，超过时淘汰最久未使用的，<=0时不限制。
+// 配置中心的监听无法取消，被淘汰的配置项的监听(及其等待协程)保留到配置项变化为止，因此未触发的监听最多保留2*size个，
+// 超过时未命中的配置项直接读取而不缓存；size<=0时监听数同样不限制。命中率等统计信息通过CacheStats获取。
+func WithCache(size int) Option {
+	return func(c *configuration) {
+		c.cache = true
+		c.cacheSize = size
+	}
+}
+
+// CacheStats 读缓存的统计信息。
+type CacheStats struct {
+	Hits      uint64 // 命中次数
+	Misses    uint64 // 未命中次数
+	Evictions uint64 // 超出容量被淘汰的次数
+	Entries   int    // 当前缓存的配置项数
+}
+
+// CacheStats 获取读缓存的统计信息，未开启读缓存时返回零值。
+func (c configuration) CacheStats() CacheStats {
+	if s, ok := c.store.(*cacheStore); ok {
+		return s.stats()
+	}
+	return CacheStats{}
+}
+
+// cacheEntry 缓存的配置项，不存在的配置项同样缓存，直到被创建。
+type cacheEntry struct {
+	key    string
+	value  string
+	exists bool
+}
+
+// cacheWatch 一个路径上未触发的监听，监听触发后该路径的下一个监听是新的cacheWatch，通过本引擎写入时gen加1；
+// 读取前后比较路径当前的cacheWatch及其gen，即可判断读取期间配置项是否变化过。
+type cacheWatch struct {
+	key string
+	gen uint64
+}
+
+// cacheStore 带LRU读缓存的StoreClient，只缓存GetValues，其他操作直接访问被包装的StoreClient。
+// 每个路径最多有一个未触发的监听：配置中心的监听无法取消，被淘汰的配置项的监听会保留到其触发，再次缓存时复用该监听。
+type cacheStore struct {
+	backends.StoreClient
+	size      int
+	mu        sync.Mutex
+	lru       *list.List // 最近使用的在前
+	items     map[string]*list.Element
+	watching  map[string]*cacheWatch // 已设置且尚未触发监听的路径
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+func newCacheStore(store backends.StoreClient, size int) *cacheStore {
+	return &cacheStore{StoreClient: store, size: size, lru: list.New(), items: make(map[string]*list.Element), watching: make(map[string]*cacheWatch)}
+}
+
+// GetValues 优先从缓存读取，未命中的配置项在没有未触发的监听时先设置监听再从配置中心读取，
+// 没有监听(如配置中心不可用、监听数已达上限)或读取期间监听已触发(其他调用者可能已缓存了更新的数据)的配置项不缓存。
+func (s *cacheStore) GetValues(keys []string) (map[string]string, error) {
+	vl := make(map[string]string, len(keys))
+	misses := make([]string, 0)
+	watches := make(map[string]*cacheWatch)
+	gens := make(map[string]uint64)
+	arm := make([]*cacheWatch, 0)
+	s.mu.Lock()
+	for _, k := range keys {
+		if el, ok := s.items[k]; ok {
+			s.lru.MoveToFront(el)
+			if e := el.Value.(*cacheEntry); e.exists {
+				vl[k] = e.value
+			}
+			s.hits.Add(1)
+			continue
+		}
+		misses = append(misses, k)
+		w, ok := s.watching[k]
+		if !ok && (s.size <= 0 || len(s.watching) < 2*s.size) {
+			w = &cacheWatch{key: k}
+			s.watching[k] = w
+			arm = append(arm, w)
+		}
+		if w != nil {
+			watches[k], gens[k] = w, w.gen
+		}
+	}
+	s.mu.Unlock()
+	if len(misses) == 0 {
+		return vl, nil
+	}
+	s.misses.Add(uint64(len(misses)))
+	// 先设置监听再读取，读取之后的变化都会使缓存失效
+	for _, w := range arm {
+		if _, ch, err := s.StoreClient.ExistsW(w.key); err == nil {
+			go s.await(w, ch)
+		} else {
+			s.mu.Lock()
+			delete(s.watching, w.key)
+			s.mu.Unlock()
+		}
+	}
+	values, err := s.StoreClient.GetValues(misses)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range misses {
+		v, ok := values[k]
+		if ok {
+			vl[k] = v
+		}
+		if w := watches[k]; w != nil && s.watching[k] == w && w.gen == gens[k] {
+			s.put(&cacheEntry{key: k, value: v, exists: ok})
+		}
+	}
+	return vl, nil
+}
+
+// await 等待路径的监听触发，触发后使缓存失效，下次未命中时重新设置监听。
+func (s *cacheStore) await(w *cacheWatch, ch <-chan backends.Event) {
+	<-ch
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.watching[w.key] == w {
+		delete(s.watching, w.key)
+	}
+	if el, ok := s.items[w.key]; ok {
+		s.remove(el)
+	}
+}
+
+// put 缓存配置项，超出容量时淘汰最久未使用的配置项，调用方需持有s.mu。
+func (s *cacheStore) put(e *cacheEntry) {
+	if el, ok := s.items[e.key]; ok {
+		s.remove(el)
+	}
+	s.items[e.key] = s.lru.PushFront(e)
+	for s.size > 0 && s.lru.Len() > s.size {
+		s.remove(s.lru.Back())
+		s.evictions.Add(1)
+	}
+}
+
+// remove 移除缓存的配置项，调用方需持有s.mu。
+func (s *cacheStore) remove(el *list.Element) {
+	delete(s.items, s.lru.Remove(el).(*cacheEntry).key)
+}
+
+func (s *cacheStore) invalidate(keys ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range keys {
+		if w, ok := s.watching[k]; ok {
+			w.gen++
+		}
+		if el, ok := s.items[k]; ok {
+			s.remove(el)
+		}
+	}
+}
+
+func (s *cacheStore) stats() CacheStats {
+	s.mu.Lock()
+	entries := s.lru.Len()
+	s.mu.Unlock()
+	return CacheStats{Hits: s.hits.Load(), Misses: s.misses.Load(), Evictions: s.evictions.Load(), Entries: entries}
+}
+
+// WatchPrefix 监听到变化后先使keys的缓存失效，保证随后的GetValues读取到变化后的数据。
+func (s *cacheStore) WatchPrefix(keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	index, err := s.StoreClient.WatchPrefix(keys, waitIndex, stopChan)
+	if index != waitIndex {
+		s.invalidate(keys...)
+	}
+	return index, err
+}
+
+func (s *cacheStore) Add(path string, value []byte, flags int32) (string, error) {
+	p, err := s.StoreClient.Add(path, value, flags)
+	s.invalidate(path, p)
+	return p, err
+}
+
+func (s *cacheStore) Modify(path string, value []byte) error {
+	defer s.invalidate(path)
+	return s.StoreClient.Modify(path, value)
+}
+
+func (s *cacheStore) Delete(path string) error {
+	defer s.invalidate(path)
+	return s.StoreClient.Delete(path)
+}
+
+func (s *cacheStore) Close() {
+	s.mu.Lock()
+	for s.lru.Len() > 0 {
+		s.remove(s.lru.Back())
+	}
+	s.mu.Unlock()
+	s.StoreClient.Close()
+}
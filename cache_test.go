@@ -0,0 +1,82 @@
+package configuration
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aluka-7/configuration/backends"
+	"github.com/aluka-7/configuration/backends/mock"
+)
+
+// pausedStore 第一次GetValues读取后暂停，直到release被关闭。
+type pausedStore struct {
+	backends.StoreClient
+	read    chan struct{}
+	release chan struct{}
+}
+
+func (s *pausedStore) GetValues(keys []string) (map[string]string, error) {
+	vl, err := s.StoreClient.GetValues(keys)
+	select {
+	case s.read <- struct{}{}:
+		<-s.release
+	default:
+	}
+	return vl, err
+}
+
+func TestCacheStalePut(t *testing.T) {
+	store, _ := mock.NewMockClient(map[string]string{"/system/base/db/host": "v1"})
+	paused := &pausedStore{StoreClient: store, read: make(chan struct{}), release: make(chan struct{})}
+	cs := newCacheStore(paused, 0)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if vl, _ := cs.GetValues([]string{"/system/base/db/host"}); vl["/system/base/db/host"] != "v1" {
+			t.Error("读取结果不匹配:", vl)
+		}
+	}()
+	<-paused.read
+	if err := cs.Modify("/system/base/db/host", []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	if vl, _ := cs.GetValues([]string{"/system/base/db/host"}); vl["/system/base/db/host"] != "v2" {
+		t.Fatal("读取结果不匹配:", vl)
+	}
+	// 修改前读取的旧数据不能覆盖缓存中的新数据
+	close(paused.release)
+	<-done
+	if vl, _ := cs.GetValues([]string{"/system/base/db/host"}); vl["/system/base/db/host"] != "v2" {
+		t.Error("旧数据覆盖了缓存:", vl)
+	}
+}
+
+func TestCacheWatchChurn(t *testing.T) {
+	data := make(map[string]string)
+	for i := 0; i < 10; i++ {
+		data[fmt.Sprintf("/system/base/db/k%d", i)] = "v"
+	}
+	store, _ := mock.NewMockClient(data)
+	c := newConfiguration(func() (backends.StoreClient, error) { return store, nil }, WithCache(2))
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 10; i++ {
+			if v, err := c.String("base", "db", "", fmt.Sprintf("k%d", i)); v != "v" || err != nil {
+				t.Fatal("读取缓存的结果不匹配:", v, err)
+			}
+		}
+	}
+	if n := store.PendingWatches(); n > 10 {
+		t.Error("每个路径最多只应有一个未触发的监听:", n)
+	}
+	if s := c.CacheStats(); s.Evictions == 0 || s.Entries != 2 {
+		t.Errorf("缓存统计不匹配:%+v", s)
+	}
+	// 监听触发后重新设置
+	c.Modify("base", "db", "", "k0", []byte("v2"))
+	if v, _ := c.String("base", "db", "", "k0"); v != "v2" {
+		t.Error("配置项变化后缓存应失效:", v)
+	}
+	if n := store.PendingWatches(); n > 10 {
+		t.Error("每个路径最多只应有一个未触发的监听:", n)
+	}
+}
@@ -0,0 +1,78 @@
+// configctl 配置中心的命令行工具。
+//
+// 用法:
+//
+//	configctl lint -schema schema.json file...
+//	configctl lint -app base -group db file...
+//
+// lint按JSON Schema校验本地的配置文件，文件按扩展名或内容特征以JSON/YAML/TOML/INI/properties格式解码。
+// schema可以是本地文件，也可以是配置中心中app/group注册的schema(连接信息取自环境变量UAF或./configuration.uaf)。
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/aluka-7/configuration"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "lint":
+		os.Exit(lint(os.Args[2:]))
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "用法: configctl lint (-schema schema.json | -app app -group group) file...")
+	os.Exit(2)
+}
+
+func lint(args []string) int {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	schemaFile := fs.String("schema", "", "本地的JSON Schema文件")
+	app := fs.String("app", "", "使用配置中心中该app注册的schema")
+	group := fs.String("group", "", "使用配置中心中该group注册的schema")
+	fs.Parse(args)
+	if fs.NArg() == 0 || len(*schemaFile) == 0 && (len(*app) == 0 || len(*group) == 0) {
+		usage()
+	}
+	var schema []byte
+	var err error
+	if len(*schemaFile) > 0 {
+		schema, err = ioutil.ReadFile(*schemaFile)
+	} else {
+		schema, err = configuration.DefaultEngine().Schema(*app, *group)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "读取schema出错:", err)
+		return 2
+	}
+	code := 0
+	for _, file := range fs.Args() {
+		data, err := ioutil.ReadFile(file)
+		if err == nil {
+			err = configuration.ValidateSchema(schema, file, data, configuration.WithExtensionCodec())
+		}
+		var se *configuration.SchemaError
+		switch {
+		case err == nil:
+			fmt.Println(file, "OK")
+		case errors.As(err, &se):
+			fmt.Println(err)
+			code = 1
+		default:
+			fmt.Fprintln(os.Stderr, file, err)
+			return 2
+		}
+	}
+	return code
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	schema := write("db.schema.json", `{"type":"object","required":["host","port"],"properties":{"port":{"type":"integer","minimum":1}}}`)
+	valid := write("db.yaml", "host: a\nport: 3306\n")
+	invalid := write("db.json", `{"host":"a","port":0}`)
+	props := write("db.props", "host=a\nport=1\n")
+	for _, c := range []struct {
+		args []string
+		code int
+	}{
+		{[]string{"-schema", schema, valid}, 0},
+		{[]string{"-schema", schema, props}, 1}, // properties的值均为字符串，port不是integer
+		{[]string{"-schema", schema, valid, invalid}, 1},
+		{[]string{"-schema", schema, filepath.Join(dir, "missing.yaml")}, 2},
+		{[]string{"-schema", filepath.Join(dir, "missing.json"), valid}, 2},
+	} {
+		if code := lint(c.args); code != c.code {
+			t.Errorf("%v的退出码不匹配\n预期:%d | 实际:%d", c.args, c.code, code)
+		}
+	}
+}
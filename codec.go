@@ -0,0 +1,468 @@
+package configuration
+
+import (
+	"bufio"
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Codec 配置数据的解码器，Clazz和Bind依次按WithCodec指定的解码器、配置项路径的格式后缀(如db.yaml，需WithExtensionCodec开启)、
+// 配置数据的内容特征选择解码器。JSONCodec同时实现了PayloadCodec和Codec。
+type Codec interface {
+	Name() string
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{}
+)
+
+func init() {
+	RegisterCodec(JSONCodec{})
+	RegisterCodec(YAMLCodec{}, "yml")
+	RegisterCodec(TOMLCodec{})
+	RegisterCodec(INICodec{}, "conf", "cfg")
+	RegisterCodec(PropertiesCodec{}, "props")
+}
+
+// RegisterCodec 注册配置数据的解码器，以解码器名称和exts作为配置项路径的格式后缀。
+func RegisterCodec(codec Codec, exts ...string) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[codec.Name()] = codec
+	for _, ext := range exts {
+		codecs[ext] = codec
+	}
+}
+
+// DecodeOption Clazz和Bind的解码选项。
+type DecodeOption func(o *decodeOptions)
+
+type decodeOptions struct {
+	codec      Codec
+	byExt      bool // 是否按路径后缀选择解码器
+	validators []func(v interface{}) error
+	merge      *MergeStrategy
+}
+
+func newDecodeOptions(opts []DecodeOption) decodeOptions {
+	var o decodeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithCodec 指定配置数据的解码器，不再按路径后缀和内容特征选择。
+func WithCodec(codec Codec) DecodeOption {
+	return func(o *decodeOptions) {
+		o.codec = codec
+	}
+}
+
+// WithExtensionCodec 按配置项路径的格式后缀(RegisterCodec注册的解码器名称和后缀，如db.yaml、client.conf)选择解码器，
+// 后缀没有注册时按内容特征选择。默认不按后缀选择，避免client.conf、db.props等只是名称带后缀的配置项被当作INI、properties解析。
+func WithExtensionCodec() DecodeOption {
+	return func(o *decodeOptions) {
+		o.byExt = true
+	}
+}
+
+// decode 按选项、路径后缀(需开启)、内容特征选择解码器解码配置数据，错误中包含配置项的完整路径。
+func decode(p string, data []byte, v interface{}, opts []DecodeOption) error {
+	o := newDecodeOptions(opts)
+	codec := o.codec
+	if codec == nil && o.byExt {
+		codecsMu.RLock()
+		codec = codecs[strings.TrimPrefix(path.Ext(p), ".")]
+		codecsMu.RUnlock()
+	}
+	if codec == nil {
+		codec = sniffCodec(data)
+	}
+	if err := codec.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("以%s格式解析配置项[%s]出错:%w", codec.Name(), p, err)
+	}
+	return nil
+}
+
+var (
+	sectionLine = regexp.MustCompile(`^\[[^\]]+\]$`)
+	// headerLine INI的[section]或TOML的[table]、[[array]]行，不含,和嵌套的[]
+	headerLine = regexp.MustCompile(`^\[\[?[^\[\],]+\]\]?$`)
+	// kvLine 以=分隔的key=value行，key中不含:
+	kvLine = regexp.MustCompile(`^[^=:\s]+\s*=`)
+)
+
+// sniffCodec 根据内容特征推断配置数据的格式：合法的JSON、以{开头或以[开头且第一行不是[section]的数据按JSON解析；
+// 含有[section]时能按TOML解析则为TOML，否则为INI；以key=value开头时能按TOML解析则为TOML，否则为Java properties；其他情况按YAML解析。
+func sniffCodec(data []byte) Codec {
+	trimmed := bytes.TrimSpace(data)
+	if json.Valid(data) || bytes.HasPrefix(trimmed, []byte("{")) {
+		return JSONCodec{}
+	}
+	if bytes.HasPrefix(trimmed, []byte("[")) {
+		first, _, _ := bytes.Cut(trimmed, []byte("\n"))
+		if !headerLine.Match(bytes.TrimSpace(first)) {
+			return JSONCodec{}
+		}
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "!") {
+			continue
+		}
+		var m map[string]interface{}
+		isTOML := toml.Unmarshal(data, &m) == nil
+		switch {
+		case sectionLine.MatchString(line) && isTOML, kvLine.MatchString(line) && isTOML:
+			return TOMLCodec{}
+		case sectionLine.MatchString(line):
+			return INICodec{}
+		case kvLine.MatchString(line):
+			return PropertiesCodec{}
+		}
+		break
+	}
+	return YAMLCodec{}
+}
+
+// YAMLCodec YAML格式的解码器。
+type YAMLCodec struct{}
+
+func (YAMLCodec) Name() string {
+	return "yaml"
+}
+
+func (YAMLCodec) Unmarshal(data []byte, v interface{}) error {
+	return yaml.Unmarshal(data, v)
+}
+
+// TOMLCodec TOML格式的解码器。
+type TOMLCodec struct{}
+
+func (TOMLCodec) Name() string {
+	return "toml"
+}
+
+func (TOMLCodec) Unmarshal(data []byte, v interface{}) error {
+	return toml.Unmarshal(data, v)
+}
+
+// INICodec INI格式的解码器，[section]下的key=value解析为以section为key的嵌套对象，section之前的key位于顶层，
+// 以#或;开头的行为注释。值均为字符串，解码到结构体时按字段类型转换，字段名取json标签或不区分大小写的字段名。
+type INICodec struct{}
+
+func (INICodec) Name() string {
+	return "ini"
+}
+
+func (INICodec) Unmarshal(data []byte, v interface{}) error {
+	m := make(map[string]interface{})
+	section := m
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case len(line) == 0 || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+		case sectionLine.MatchString(line):
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if s, ok := m[name].(map[string]interface{}); ok {
+				section = s
+			} else {
+				section = make(map[string]interface{})
+				m[name] = section
+			}
+		default:
+			k, val, ok := strings.Cut(line, "=")
+			if !ok {
+				return fmt.Errorf("第%d行[%s]不是key=value格式", n, line)
+			}
+			section[strings.TrimSpace(k)] = strings.TrimSpace(val)
+		}
+	}
+	return assign(reflect.ValueOf(v), m)
+}
+
+// PropertiesCodec Java properties格式的解码器，支持=、:或空白分隔，以#或!开头的行为注释，行尾\续行，
+// 以.分隔的key解析为嵌套对象(如db.host)。值均为字符串，解码到结构体时按字段类型转换。
+type PropertiesCodec struct{}
+
+func (PropertiesCodec) Name() string {
+	return "properties"
+}
+
+func (PropertiesCodec) Unmarshal(data []byte, v interface{}) error {
+	m := make(map[string]interface{})
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	var logical string
+	for scanner.Scan() {
+		line := strings.TrimLeft(scanner.Text(), " \t\f")
+		if len(logical) == 0 && (len(line) == 0 || line[0] == '#' || line[0] == '!') {
+			continue
+		}
+		if strings.HasSuffix(line, "\\") && !strings.HasSuffix(line, "\\\\") {
+			logical += line[:len(line)-1]
+			continue
+		}
+		logical += line
+		k, val := splitProperty(logical)
+		logical = ""
+		node := m
+		parts := strings.Split(k, ".")
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := node[part].(map[string]interface{})
+			if !ok {
+				if _, exists := node[part]; exists {
+					return fmt.Errorf("属性[%s]与其上级属性冲突", k)
+				}
+				child = make(map[string]interface{})
+				node[part] = child
+			}
+			node = child
+		}
+		last := parts[len(parts)-1]
+		if _, ok := node[last].(map[string]interface{}); ok {
+			return fmt.Errorf("属性[%s]与其下级属性冲突", k)
+		}
+		node[last] = val
+	}
+	return assign(reflect.ValueOf(v), m)
+}
+
+// splitProperty 按第一个未转义的=、:或空白分隔key和value，并处理\t、\n、\uXXXX等转义。
+func splitProperty(line string) (string, string) {
+	i := 0
+	for ; i < len(line); i++ {
+		if line[i] == '\\' {
+			i++
+			continue
+		}
+		if line[i] == '=' || line[i] == ':' || line[i] == ' ' || line[i] == '\t' {
+			break
+		}
+	}
+	if i > len(line) {
+		i = len(line)
+	}
+	k, rest := line[:i], strings.TrimLeft(line[i:], " \t\f")
+	if len(rest) > 0 && (rest[0] == '=' || rest[0] == ':') {
+		rest = strings.TrimLeft(rest[1:], " \t\f")
+	}
+	return unescapeProperty(k), unescapeProperty(rest)
+}
+
+func unescapeProperty(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u':
+			if i+4 < len(s) {
+				if r, err := strconv.ParseUint(s[i+1:i+5], 16, 32); err == nil {
+					b.WriteRune(rune(r))
+					i += 4
+					continue
+				}
+			}
+			b.WriteByte('u')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// assign 将INI/properties解析出的字符串或嵌套map赋值给dst，字符串按目标类型转换。
+func assign(dst reflect.Value, src interface{}) error {
+	if dst.Kind() == reflect.Ptr {
+		if dst.IsNil() {
+			if !dst.CanSet() {
+				return fmt.Errorf("解码的目标不能为nil")
+			}
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		if dst.Type().Implements(textUnmarshalerType) {
+			if s, ok := src.(string); ok {
+				return dst.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+			}
+		}
+		return assign(dst.Elem(), src)
+	}
+	if dst.Kind() == reflect.Interface && dst.NumMethod() == 0 {
+		dst.Set(reflect.ValueOf(src))
+		return nil
+	}
+	switch s := src.(type) {
+	case string:
+		return setString(dst, s)
+	case map[string]interface{}:
+		switch dst.Kind() {
+		case reflect.Struct:
+			return assignStruct(dst, s)
+		case reflect.Map:
+			if dst.Type().Key().Kind() != reflect.String {
+				return fmt.Errorf("不支持的map类型:%s", dst.Type())
+			}
+			if dst.IsNil() {
+				dst.Set(reflect.MakeMapWithSize(dst.Type(), len(s)))
+			}
+			for k, v := range s {
+				elem := reflect.New(dst.Type().Elem()).Elem()
+				if err := assign(elem, v); err != nil {
+					return fmt.Errorf("%s:%w", k, err)
+				}
+				dst.SetMapIndex(reflect.ValueOf(k).Convert(dst.Type().Key()), elem)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("不能将%T赋值给%s", src, dst.Type())
+}
+
+func assignStruct(dst reflect.Value, src map[string]interface{}) error {
+	t := dst.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Name
+		if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if len(tag) > 0 {
+			name = tag
+		}
+		v, ok := src[name]
+		if !ok {
+			for k, kv := range src {
+				if strings.EqualFold(k, name) {
+					v, ok = kv, true
+					break
+				}
+			}
+		}
+		if !ok {
+			continue
+		}
+		if err := assign(dst.Field(i), v); err != nil {
+			return fmt.Errorf("%s:%w", name, err)
+		}
+	}
+	return nil
+}
+
+// setString 将字符串按dst的类型转换后赋值，支持encoding.TextUnmarshaler、time.Duration、基本类型，
+// 以及JSON数组/以逗号分隔的切片和JSON对象/以逗号分隔的key=value的map。
+func setString(dst reflect.Value, s string) error {
+	if dst.CanAddr() && dst.Addr().Type().Implements(textUnmarshalerType) {
+		return dst.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+	if dst.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		dst.SetInt(int64(d))
+		return nil
+	}
+	switch dst.Kind() {
+	case reflect.String:
+		dst.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		dst.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, dst.Type().Bits())
+		if err != nil {
+			return err
+		}
+		dst.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, dst.Type().Bits())
+		if err != nil {
+			return err
+		}
+		dst.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, dst.Type().Bits())
+		if err != nil {
+			return err
+		}
+		dst.SetFloat(n)
+	case reflect.Slice:
+		parts, err := parseStringSlice(strings.TrimSpace(s))
+		if err != nil {
+			return err
+		}
+		slice := reflect.MakeSlice(dst.Type(), len(parts), len(parts))
+		for i, p := range parts {
+			if err = setString(slice.Index(i), p); err != nil {
+				return err
+			}
+		}
+		dst.Set(slice)
+	case reflect.Map:
+		kvs, err := parseStringMap(strings.TrimSpace(s))
+		if err != nil {
+			return err
+		}
+		m := reflect.MakeMapWithSize(dst.Type(), len(kvs))
+		for k, v := range kvs {
+			key, elem := reflect.New(dst.Type().Key()).Elem(), reflect.New(dst.Type().Elem()).Elem()
+			if err = setString(key, k); err != nil {
+				return err
+			}
+			if err = setString(elem, v); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, elem)
+		}
+		dst.Set(m)
+	case reflect.Ptr:
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return setString(dst.Elem(), s)
+	default:
+		return fmt.Errorf("不支持的类型:%s", dst.Type())
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package configuration
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/golang/snappy"
+)
+
+// Compression 事件数据的压缩算法。
+type Compression string
+
+const (
+	CompressionNone   Compression = ""
+	CompressionGzip   Compression = "gzip"
+	CompressionSnappy Compression = "snappy"
+)
+
+func (c Compression) compress(data []byte) ([]byte, error) {
+	switch c {
+	case CompressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionSnappy:
+		return snappy.Encode(nil, data), nil
+	case CompressionNone:
+		return data, nil
+	}
+	return nil, fmt.Errorf("不支持的压缩算法[%s]", c)
+}
+
+func (c Compression) decompress(data []byte) ([]byte, error) {
+	switch c {
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	case CompressionSnappy:
+		return snappy.Decode(nil, data)
+	case CompressionNone:
+		return data, nil
+	}
+	return nil, fmt.Errorf("不支持的压缩算法[%s]", c)
+}
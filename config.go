@@ -3,12 +3,14 @@ package configuration
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/samuel/go-zookeeper/zk"
 	"io/ioutil"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/aluka-7/configuration/backends"
 	"github.com/aluka-7/utils"
@@ -47,51 +49,120 @@ func NewStoreConfig() (conf backends.StoreConfig) {
 	}
 	return
 }
-func DefaultEngine() Configuration {
-	return Engine(NewStoreConfig())
+func DefaultEngine(opts ...Option) Configuration {
+	return Engine(NewStoreConfig(), opts...)
 }
-func MockEngine(t *testing.T, conf backends.StoreConfig) Configuration {
+func MockEngine(t *testing.T, conf backends.StoreConfig, opts ...Option) Configuration {
 	fmt.Println("Loading Aluka configuration Mock Engine")
-	store, err := backends.NewMock(conf)
-	if err != nil {
-		panic(err)
-	}
-	return &configuration{store}
+	return newConfiguration(func() (backends.StoreClient, error) {
+		return backends.NewMock(conf)
+	}, opts...)
 }
 
 // Engine 获取配置管理引擎的唯一实例。
-func Engine(conf backends.StoreConfig) Configuration {
+func Engine(conf backends.StoreConfig, opts ...Option) Configuration {
 	fmt.Println("Loading Aluka configuration Engine")
-	store, err := backends.New(conf)
-	if err != nil {
-		panic(err)
+	return newConfiguration(func() (backends.StoreClient, error) {
+		return backends.New(conf)
+	}, opts...)
+}
+
+// newConfiguration 按opts创建配置管理引擎，开启本地快照时连接配置中心失败不会panic。
+func newConfiguration(connect func() (backends.StoreClient, error), opts ...Option) *configuration {
+	c := &configuration{}
+	for _, opt := range opts {
+		opt(c)
 	}
-	return &configuration{store}
+	if len(c.snapshot) > 0 {
+		store, err := newSnapshotStore(c.snapshot, c.snapshotKey, connect)
+		if err != nil {
+			panic(err)
+		}
+		c.store = store
+	} else {
+		store, err := connect()
+		if err != nil {
+			panic(err)
+		}
+		c.store = store
+	}
+	if c.cache {
+		c.store = newCacheStore(c.store, c.cacheSize)
+	}
+	return c
 }
 
 type Configuration interface {
 	Values(app, group, tag string, path []string) (map[string]string, error)
+	Resolve(app, group, tag string, path []string) (map[string]Resolved, error)
 	String(app, group, tag, path string) (string, error)
-	Clazz(app, group, tag, path string, clazz interface{}) error
+	Clazz(app, group, tag, path string, clazz interface{}, opts ...DecodeOption) error
+	Unmarshal(app, group, tag string, v interface{}, opts ...DecodeOption) error
+	Int(app, group, tag, path string) (int, error)
+	IntDefault(app, group, tag, path string, def int) int
+	Int64(app, group, tag, path string) (int64, error)
+	Int64Default(app, group, tag, path string, def int64) int64
+	Float64(app, group, tag, path string) (float64, error)
+	Float64Default(app, group, tag, path string, def float64) float64
+	Bool(app, group, tag, path string) (bool, error)
+	BoolDefault(app, group, tag, path string, def bool) bool
+	Duration(app, group, tag, path string) (time.Duration, error)
+	DurationDefault(app, group, tag, path string, def time.Duration) time.Duration
+	StringSlice(app, group, tag, path string) ([]string, error)
+	StringSliceDefault(app, group, tag, path string, def []string) []string
+	StringMap(app, group, tag, path string) (map[string]string, error)
+	StringMapDefault(app, group, tag, path string, def map[string]string) map[string]string
 	Get(app, group, tag string, path []string, parser ChangedListener)
 	Watch(app, group, tag, path string, callback EndpointCacher)
-	Lock(app, group, tag, path string) *zk.Lock
+	Lock(app, group, tag, path string) backends.Locker
+	RWLock(app, group, tag, path string) *RWLock
+	Semaphore(app, group, tag, path string, size int) (*Semaphore, error)
 	Add(app, group, tag, path string, value []byte, flags int32) (string, error)
 	Modify(app, group, tag, path string, value []byte) error
 	Delete(app, group, tag, path string) error
+	RegisterSchema(app, group string, schema []byte) error
+	Schema(app, group string) ([]byte, error)
+	DeleteSchema(app, group string) error
+	Stale() bool
+	CacheStats() CacheStats
 }
 
 type configuration struct {
-	store backends.StoreClient
+	store   backends.StoreClient
+	layered bool          // 是否开启分层查找
+	common  string        // app级别公共配置的分组
+	merge   MergeStrategy // 分层查找时Clazz和Bind默认的合并策略
+
+	interpolate bool   // 是否开启变量插值
+	snapshot    string // 本地快照文件，为空时不使用本地快照
+	snapshotKey []byte // 本地快照的AES密钥
+	cache       bool   // 是否开启读缓存
+	cacheSize   int    // 读缓存最多缓存的配置项数
 }
 
-func (c configuration) Lock(app, group, tag, path string) *zk.Lock {
+// Lock 获取指定路径的分布式互斥锁，锁支持超时/取消(LockContext)、TryLock，并在会话失效导致锁丢失时通过Lost()通知持有者。
+func (c configuration) Lock(app, group, tag, path string) backends.Locker {
 	path = c.maskPath(app, group, tag, path)
 	return c.store.Lock(path)
 }
 
+// RWLock 获取指定路径的分布式读写锁。
+func (c configuration) RWLock(app, group, tag, path string) *RWLock {
+	return &RWLock{store: c.store, path: c.maskPath(app, group, tag, path)}
+}
+
+// Semaphore 获取指定路径的分布式计数信号量，集群内最多允许size个持有者同时持有许可，size<=0时返回错误。
+func (c configuration) Semaphore(app, group, tag, path string, size int) (*Semaphore, error) {
+	return newSemaphore(c.store, c.maskPath(app, group, tag, path), size)
+}
+
+// Add 创建配置项，app/group注册了JSON Schema时先按schema校验配置数据，不符合时返回*SchemaError。
 func (c configuration) Add(app, group, tag, path string, value []byte, flags int32) (string, error) {
 	path = c.maskPath(app, group, tag, path)
+	if err := c.checkSchema(app, group, path, value); err != nil {
+		log.Err(err).Msgf("创建[%s]的配置信息出错:%+v", path, err)
+		return "", err
+	}
 	s, err := c.store.Add(path, value, flags)
 	if err != nil {
 		log.Err(err).Msgf("创建[%s]的配置信息出错:%+v", path, err)
@@ -101,8 +172,13 @@ func (c configuration) Add(app, group, tag, path string, value []byte, flags int
 	return s, err
 }
 
+// Modify 更新配置项，app/group注册了JSON Schema时先按schema校验配置数据，不符合时返回*SchemaError。
 func (c configuration) Modify(app, group, tag, path string, value []byte) error {
 	path = c.maskPath(app, group, tag, path)
+	if err := c.checkSchema(app, group, path, value); err != nil {
+		log.Err(err).Msgf("更新[%s]的配置信息出错:%+v", path, err)
+		return err
+	}
 	err := c.store.Modify(path, value)
 	if err != nil {
 		log.Err(err).Msgf("更新[%s]的配置信息出错:%+v", path, err)
@@ -132,72 +208,111 @@ func (configuration) maskPath(app, group, tag, path string) string {
 }
 
 // Values 获取多个配置项的配置信息，返回原始的配置数据格式(map集合)，如果获取失败则抛出异常。
-// key 配置项的路径，如：/abc/dd
+// key 配置项的路径，如：/abc/dd。开启分层查找时key仍为带tag的完整路径，实际来源可通过Resolve获取。
 func (c configuration) Values(app, group, tag string, path []string) (map[string]string, error) {
-	_path := make([]string, len(path))
-	for i, v := range path {
-		_path[i] = c.maskPath(app, group, tag, v)
-	}
-	vl, err := c.store.GetValues(_path)
+	r, err := c.Resolve(app, group, tag, path)
 	if err != nil {
-		log.Err(err).Msgf("获取多个配置项[%v]的配置信息出错:%+v", path, err)
-	} else {
-		log.Info().Msgf("获取多个配置项为:%+v", vl)
+		return nil, err
+	}
+	vl := make(map[string]string, len(r))
+	for k, v := range r {
+		vl[k] = v.Value
 	}
-	return vl, err
+	log.Info().Msgf("获取多个配置项为:%+v", vl)
+	return vl, nil
 }
 
 // String 获取指定配置项的配置信息，返回原始的配置数据格式，如果获取失败则抛出异常。
+// 配置项不存在时返回的错误满足errors.Is(err, backends.ErrNoNode)。
 func (c configuration) String(app, group, tag, path string) (string, error) {
-	path = c.maskPath(app, group, tag, path)
-	vl, err := c.store.GetValues([]string{path})
+	_, v, err := c.value(app, group, tag, path)
+	return v, err
+}
+
+// Clazz 获取指定配置项的配置信息，并且将配置信息转换为指定的Go结构体，如果获取失败或转换失败则抛出异常。
+// 配置信息默认按JSON解析，也可以通过WithCodec指定格式，或由路径后缀(如db.yaml)、内容特征推断YAML/TOML/INI/properties格式。
+// 解码后按validate标签、Validator接口和WithValidator校验，校验失败时返回错误且clazz保持原值。
+// 开启分层查找时可以按合并策略(WithMergeStrategy/WithMerge)合并各层的配置。
+func (c configuration) Clazz(app, group, tag, path string, clazz interface{}, opts ...DecodeOption) error {
+	layers := c.layers(app, group, tag, path)
+	vl, _, err := c.getValues([][]string{layers}, c.strategy(opts) != MergeReplace)
 	if err != nil {
-		log.Err(err).Msgf("获取多个配置项[%s]的配置信息出错:%+v", path, err)
-	} else {
-		log.Info().Msgf("获取多个配置项为:%+v", vl)
+		log.Err(err).Msgf("获取配置项[%s]的配置信息出错:%+v", layers[0], err)
+		return err
 	}
-	return vl[path], err
+	r, opts, ok, err := c.document(layers, vl, opts)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return notFound(layers)
+	}
+	log.Info().Msgf("获取配置项[%s]为:%s", r.Source, r.Value)
+	return decodeValidated(r.Source, []byte(r.Value), clazz, opts)
 }
 
-// Clazz 获取指定配置项的配置信息，并且将配置信息（JSON格式的）转换为指定的Go结构体，如果获取失败或转换失败则抛出异常。
-func (c configuration) Clazz(app, group, tag, path string, clazz interface{}) error {
-	path = c.maskPath(app, group, tag, path)
-	vl, err := c.store.GetValues([]string{path})
+// value 按分层查找获取单个配置项，返回配置数据实际来源的完整路径和配置数据。
+func (c configuration) value(app, group, tag, path string) (string, string, error) {
+	paths := c.layers(app, group, tag, path)
+	vl, _, err := c.getValues([][]string{paths}, false)
 	if err != nil {
-		log.Err(err).Msgf("获取多个配置项[%s]的配置信息出错:%+v", path, err)
-	} else {
-		log.Info().Msgf("获取多个配置项为:%+v", vl)
+		log.Err(err).Msgf("获取配置项[%s]的配置信息出错:%+v", paths[0], err)
+		return paths[0], "", err
 	}
-	err = json.Unmarshal([]byte(vl[path]), clazz)
-	return err
+	r, ok := resolveLayers([][]string{paths}, vl)[paths[0]]
+	if !ok {
+		return paths[0], "", notFound(paths)
+	}
+	log.Info().Msgf("获取配置项[%s]为:%s", r.Source, r.Value)
+	return r.Source, r.Value, nil
 }
 
 // Get 获取指定路径下的配置信息，并实现监听，当有数据变化时自动调用parser(配置数据的解析器，业务系统自定义实现)进行解析。
+// 开启分层查找时监听所有候选路径，parser收到的数据与Values一致；开启变量插值时同时监听被引用的配置项。
 func (c configuration) Get(app, group, tag string, path []string, parser ChangedListener) {
-	_path := make([]string, len(path))
+	candidates := make([][]string, len(path))
 	for i, v := range path {
-		_path[i] = c.maskPath(app, group, tag, v)
+		candidates[i] = c.layers(app, group, tag, v)
 	}
-	vl, err := c.store.GetValues(_path)
+	if c.layered {
+		parser = &layeredListener{candidates: candidates, listener: parser}
+	}
+	vl, refs, sentinel, err := c.armedValues(candidates, false)
 	if err != nil {
 		log.Err(err).Msgf("获取指定路径[%v]下的配置信息,并实现监听,当有数据变化时自动调用解析器进行解析出错:%+v", path, err)
 	} else {
 		log.Info().Msgf("获取多个配置项为:%v", vl)
 	}
 	parser.Changed(vl)
-	go WatchProcessor(_path, c.store).Process(parser)
+	c.watch(candidates, false, refs, sentinel, parser)
 }
 
+// Watch 监听指定路径下的子节点(如服务实例)，子节点变化时通过callback同步。
+// 配置中心不可用(如本地快照的降级模式)时记录错误并按watchRetryInterval起、逐次加倍至watchRetryMaxInterval的间隔重试，
+// 直到配置中心恢复；路径不存在、客户端已关闭等其他错误时返回。
 func (c configuration) Watch(app, group, tag, path string, callback EndpointCacher) {
 	path = c.maskPath(app, group, tag, path)
-	if err := c.listService(path, callback); err != nil {
-		return
-	}
+	listed := false
+	backoff := watchRetryInterval
 	for {
-		snapshot, _, ch, err := c.store.Client().ChildrenW(path)
+		if !listed {
+			if err := c.listService(path, callback); err != nil {
+				if !c.retryWatch(path, err, &backoff) {
+					return
+				}
+				continue
+			}
+			listed = true
+		}
+		snapshot, ch, err := c.store.ChildrenW(path)
 		if err != nil {
-			return
+			log.Err(err).Msgf("监听[%s]的子节点出错:%+v", path, err)
+			if !c.retryWatch(path, err, &backoff) {
+				return
+			}
+			continue
 		}
+		backoff = watchRetryInterval
 		select {
 		case e := <-ch:
 			switch e.Type {
@@ -205,9 +320,7 @@ func (c configuration) Watch(app, group, tag, path string, callback EndpointCach
 				for _, v := range snapshot {
 					callback.Del(v)
 				}
-				if err := c.listService(path, callback); err != nil {
-					continue
-				}
+				listed = false
 			case zk.EventNodeDeleted:
 				for _, v := range snapshot {
 					callback.Del(v)
@@ -217,14 +330,32 @@ func (c configuration) Watch(app, group, tag, path string, callback EndpointCach
 	}
 }
 
+const (
+	watchRetryInterval    = time.Second * 2  // Watch在配置中心不可用时第一次重试的间隔
+	watchRetryMaxInterval = time.Second * 30 // Watch重试的最大间隔
+)
+
+// retryWatch 配置中心不可用时等待backoff后返回true并将backoff加倍，其他错误返回false。
+func (c configuration) retryWatch(path string, err error, backoff *time.Duration) bool {
+	if !unavailable(err) || errors.Is(err, zk.ErrClosing) {
+		return false
+	}
+	log.Warn().Msgf("配置中心不可用，%v后重试监听[%s]", *backoff, path)
+	time.Sleep(*backoff)
+	if *backoff *= 2; *backoff > watchRetryMaxInterval {
+		*backoff = watchRetryMaxInterval
+	}
+	return true
+}
+
 func (c configuration) listService(path string, callback EndpointCacher) error {
-	childs, _, err := c.store.Client().Children(path)
+	childs, err := c.store.Children(path)
 	if err != nil {
 		log.Err(err).Msgf("Children Error")
 		return err
 	}
 	for _, sn := range childs {
-		value, _, err := c.store.Client().Get(fmt.Sprintf("%s/%s", path, sn))
+		value, _, err := c.store.Get(fmt.Sprintf("%s/%s", path, sn))
 		if err != nil {
 			log.Err(err).Msgf("Get Error")
 			return err
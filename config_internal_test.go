@@ -0,0 +1,80 @@
+package configuration
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aluka-7/configuration/backends"
+	"github.com/aluka-7/configuration/backends/mock"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// unavailableStore 前failures次Children返回zk.ErrNoServer，模拟暂时不可用的配置中心。
+type unavailableStore struct {
+	backends.StoreClient
+	failures atomic.Int32
+}
+
+func (s *unavailableStore) Children(path string) ([]string, error) {
+	if s.failures.Add(-1) >= 0 {
+		return nil, zk.ErrNoServer
+	}
+	return s.StoreClient.Children(path)
+}
+
+type endpoints chan string
+
+func (e endpoints) Add(sn string, _ []byte) { e <- sn }
+func (e endpoints) Edit(string, []byte)     {}
+func (e endpoints) Del(string)              {}
+
+func TestWatchRetry(t *testing.T) {
+	store, _ := mock.NewMockClient(map[string]string{"/system/base/db/servers/s1": "10.0.0.1"})
+	unavailable := &unavailableStore{StoreClient: store}
+	unavailable.failures.Store(1)
+	c := newConfiguration(func() (backends.StoreClient, error) { return unavailable, nil })
+	added := make(endpoints, 1)
+	go c.Watch("base", "db", "", "servers", added)
+	select {
+	case sn := <-added:
+		if sn != "s1" {
+			t.Error("同步的子节点不匹配:", sn)
+		}
+	case <-time.After(watchRetryInterval * 2):
+		t.Fatal("配置中心恢复后Watch应重试并同步子节点")
+	}
+}
+
+// countingStore 统计每个路径被GetValues读取的次数。
+type countingStore struct {
+	backends.StoreClient
+	reads map[string]int
+}
+
+func (s *countingStore) GetValues(keys []string) (map[string]string, error) {
+	for _, k := range keys {
+		s.reads[k]++
+	}
+	return s.StoreClient.GetValues(keys)
+}
+
+func TestInterpolationReadsReferenceOnce(t *testing.T) {
+	store, _ := mock.NewMockClient(map[string]string{
+		"/system/base/common/host": "db.internal",
+		"/system/base/common/addr": "${base/common/host}:3306",
+		"/system/base/db/a":        "${base/common/addr}",
+		"/system/base/db/b":        "${base/common/addr}/${base/common/host}",
+	})
+	counting := &countingStore{StoreClient: store, reads: make(map[string]int)}
+	c := newConfiguration(func() (backends.StoreClient, error) { return counting, nil }, WithInterpolation())
+	vl, err := c.Values("base", "db", "", []string{"a", "b"})
+	if err != nil || vl["/system/base/db/b"] != "db.internal:3306/db.internal" {
+		t.Fatal("插值的结果不匹配:", vl, err)
+	}
+	for _, ref := range []string{"/system/base/common/host", "/system/base/common/addr"} {
+		if n := counting.reads[ref]; n != 1 {
+			t.Errorf("同一次读取中被引用的配置项[%s]应只读取一次,实际:%d", ref, n)
+		}
+	}
+}
@@ -1,13 +1,21 @@
 package configuration_test
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/aluka-7/configuration"
 	"github.com/aluka-7/configuration/backends"
 	"os"
 	"os/signal"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestString(t *testing.T) {
@@ -107,11 +115,984 @@ func (b *Server) Del(sn string) {
 }
 
 func TestWatch(t *testing.T) {
+	if len(os.Getenv("UAF")) == 0 {
+		t.Skip("需要在环境变量UAF中配置可用的配置中心")
+	}
 	conf := configuration.DefaultEngine()
 	var server = new(Server)
 	go conf.Watch("test", "game", "", "server", server)
 
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt)
 	<-quit
 }
+
+func TestLock(t *testing.T) {
+	conf := configuration.MockEngine(t, backends.StoreConfig{Exp: map[string]string{}})
+	l1 := conf.Lock("base", "job", "", "sync")
+	l2 := conf.Lock("base", "job", "", "sync")
+	if err := l1.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l1.Lock(); err != backends.ErrDeadlock {
+		t.Error("重复加锁应返回ErrDeadlock,实际:", err)
+	}
+	if ok, _ := l2.TryLock(); ok {
+		t.Error("锁已被持有时TryLock不应成功")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := l2.LockContext(ctx); err != context.DeadlineExceeded {
+		t.Error("等待锁超时应返回DeadlineExceeded,实际:", err)
+	}
+	if err := l1.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l1.Unlock(); err != backends.ErrNotLocked {
+		t.Error("未持有锁时释放应返回ErrNotLocked,实际:", err)
+	}
+	if ok, _ := l2.TryLock(); !ok {
+		t.Error("锁释放后TryLock应成功")
+	}
+}
+
+func TestLockLost(t *testing.T) {
+	store, _ := backends.NewMock(backends.StoreConfig{})
+	l := store.Lock("/system/base/job/sync")
+	if err := l.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	select {
+	case <-l.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("会话关闭后未收到锁丢失通知")
+	}
+	if err := l.Unlock(); err != backends.ErrLockLost {
+		t.Error("锁丢失后释放应返回ErrLockLost,实际:", err)
+	}
+}
+
+func TestTypedValues(t *testing.T) {
+	conf := configuration.MockEngine(t, backends.StoreConfig{Exp: map[string]string{
+		"/system/base/db/port":    " 3306",
+		"/system/base/db/size":    "10737418240",
+		"/system/base/db/ratio":   "0.75",
+		"/system/base/db/debug":   "true",
+		"/system/base/db/timeout": "1m30s",
+		"/system/base/db/hosts":   "a, b,c",
+		"/system/base/db/zones":   "[\"x\",\"y\"]",
+		"/system/base/db/labels":  "env=prod, team = core",
+		"/system/base/db/bad":     "abc",
+	}})
+	if v, err := conf.Int("base", "db", "", "port"); v != 3306 || err != nil {
+		t.Error("Int不匹配:", v, err)
+	}
+	if v, err := conf.Int64("base", "db", "", "size"); v != 10737418240 || err != nil {
+		t.Error("Int64不匹配:", v, err)
+	}
+	if v, err := conf.Float64("base", "db", "", "ratio"); v != 0.75 || err != nil {
+		t.Error("Float64不匹配:", v, err)
+	}
+	if v, err := conf.Bool("base", "db", "", "debug"); !v || err != nil {
+		t.Error("Bool不匹配:", v, err)
+	}
+	if v, err := conf.Duration("base", "db", "", "timeout"); v != 90*time.Second || err != nil {
+		t.Error("Duration不匹配:", v, err)
+	}
+	if v, err := conf.StringSlice("base", "db", "", "hosts"); !reflect.DeepEqual(v, []string{"a", "b", "c"}) || err != nil {
+		t.Error("StringSlice不匹配:", v, err)
+	}
+	if v, err := conf.StringSlice("base", "db", "", "zones"); !reflect.DeepEqual(v, []string{"x", "y"}) || err != nil {
+		t.Error("StringSlice(JSON)不匹配:", v, err)
+	}
+	if v, err := conf.StringMap("base", "db", "", "labels"); !reflect.DeepEqual(v, map[string]string{"env": "prod", "team": "core"}) || err != nil {
+		t.Error("StringMap不匹配:", v, err)
+	}
+
+	_, err := conf.Int("base", "db", "", "bad")
+	if err == nil || !strings.Contains(err.Error(), "/system/base/db/bad") {
+		t.Error("解析失败的错误应包含配置项的完整路径:", err)
+	}
+	if _, err = conf.Bool("base", "db", "", "missing"); !errors.Is(err, backends.ErrNoNode) || !strings.Contains(err.Error(), "/system/base/db/missing") {
+		t.Error("配置项不存在时应返回ErrNoNode:", err)
+	}
+	if v := conf.IntDefault("base", "db", "", "bad", 5); v != 5 {
+		t.Error("解析失败时应返回默认值:", v)
+	}
+	if v := conf.DurationDefault("base", "db", "", "missing", time.Second); v != time.Second {
+		t.Error("配置项不存在时应返回默认值:", v)
+	}
+	if v := conf.IntDefault("base", "db", "", "port", 5); v != 3306 {
+		t.Error("配置项存在时不应返回默认值:", v)
+	}
+}
+
+func TestBind(t *testing.T) {
+	conf := configuration.MockEngine(t, backends.StoreConfig{Exp: map[string]string{
+		"/system/base/rpc/client/base": "{\"key\":\"client\",\"value\":\"base\"}",
+	}})
+	v, err := configuration.Bind[test](conf, "base", "rpc", "client", "base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	if v.Load().Value != "base" {
+		t.Error("初始加载的结果不匹配:", v.Load())
+	}
+	changed, rejected := make(chan [2]test, 1), make(chan error, 1)
+	v.OnChange(func(old, new test) { changed <- [2]test{old, new} })
+	v.OnReject(func(err error) { rejected <- err })
+
+	conf.Modify("base", "rpc", "client", "base", []byte("{\"key\":\"client\",\"value\":\"v2\"}"))
+	select {
+	case c := <-changed:
+		if c[0].Value != "base" || c[1].Value != "v2" || v.Load().Value != "v2" {
+			t.Error("变化后的结果不匹配:", c, v.Load())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("未收到配置变化通知")
+	}
+
+	// 解析失败时保留上一次的值
+	conf.Modify("base", "rpc", "client", "base", []byte("not json"))
+	select {
+	case <-rejected:
+	case <-time.After(time.Second):
+		t.Fatal("解析失败时应通过OnReject报告")
+	}
+	if v.Load().Value != "v2" {
+		t.Error("解析失败时应保留上一次的值:", v.Load())
+	}
+
+	if _, err = configuration.Bind[int](conf, "base", "rpc", "client", "missing"); !errors.Is(err, backends.ErrNoNode) {
+		t.Error("配置项不存在时应返回ErrNoNode:", err)
+	}
+	s, err := configuration.Bind[string](conf, "base", "rpc", "client", "base")
+	if err != nil || s.Load() != "not json" {
+		t.Error("string类型应使用原始数据:", s.Load(), err)
+	}
+	s.Close()
+}
+
+type dbConfig struct {
+	Host    string        `json:"host" yaml:"host" toml:"host"`
+	Port    int           `json:"port" yaml:"port" toml:"port"`
+	Timeout time.Duration `json:"timeout"`
+	Tags    []string      `json:"tags" yaml:"tags" toml:"tags"`
+}
+
+func TestClazzFormats(t *testing.T) {
+	conf := configuration.MockEngine(t, backends.StoreConfig{Exp: map[string]string{
+		"/system/base/db/json":         `{"host":"a","port":1}`,
+		"/system/base/db/main.yaml":    "host: a\nport: 1\n",
+		"/system/base/db/main.toml":    "host = \"a\"\nport = 1\n",
+		"/system/base/db/sniff-yaml":   "# 数据库\nhost: a\nport: 1\n",
+		"/system/base/db/sniff-toml":   "host = \"a\"\nport = 1\n",
+		"/system/base/db/sniff-ini":    "[system]\nid=1801\nhostIp=192.168.32.181\n\n[ds]\nforceUseLocal=false\n",
+		"/system/base/db/sniff-prop":   "# 数据库\nhost=a\nport : 1\ntimeout=3s\ntags=x, y\n",
+		"/system/base/db/explicit":     "host=a\nport=1\n",
+		"/system/base/db/client.conf":  "host: b\nport: 2\n",
+		"/system/base/db/broken-array": `["a", "b",]`,
+	}})
+	for _, p := range []string{"json", "main.yaml", "main.toml", "sniff-yaml", "sniff-toml", "sniff-prop"} {
+		var db dbConfig
+		if err := conf.Clazz("base", "db", "", p, &db); err != nil || db.Host != "a" || db.Port != 1 {
+			t.Errorf("%s解析结果不匹配:%+v %v", p, db, err)
+		}
+	}
+	var db dbConfig
+	conf.Clazz("base", "db", "", "sniff-prop", &db)
+	if db.Timeout != 3*time.Second || !reflect.DeepEqual(db.Tags, []string{"x", "y"}) {
+		t.Errorf("properties的值应按字段类型转换:%+v", db)
+	}
+
+	var app struct {
+		System struct {
+			ID     int    `json:"id"`
+			HostIP string `json:"hostIp"`
+		}
+		DS map[string]string
+	}
+	if err := conf.Clazz("base", "db", "", "sniff-ini", &app); err != nil || app.System.ID != 1801 || app.System.HostIP != "192.168.32.181" || app.DS["forceUseLocal"] != "false" {
+		t.Errorf("ini解析结果不匹配:%+v %v", app, err)
+	}
+
+	var m map[string]interface{}
+	if err := conf.Clazz("base", "db", "", "explicit", &m, configuration.WithCodec(configuration.INICodec{})); err != nil || m["host"] != "a" {
+		t.Errorf("应使用指定的解码器:%+v %v", m, err)
+	}
+	if err := conf.Clazz("base", "db", "", "main.yaml", &m, configuration.WithCodec(configuration.JSONCodec{})); err == nil || !strings.Contains(err.Error(), "/system/base/db/main.yaml") {
+		t.Error("解析失败的错误应包含配置项的完整路径:", err)
+	}
+	v, err := configuration.Bind[dbConfig](conf, "base", "db", "", "main.toml", configuration.WithExtensionCodec())
+	if err != nil || v.Load().Host != "a" {
+		t.Error("Bind应按路径后缀选择解码器:", err)
+	}
+	v.Close()
+
+	// 默认不按路径后缀选择解码器
+	if err := conf.Clazz("base", "db", "", "client.conf", &db); err != nil || db.Host != "b" {
+		t.Errorf("未开启WithExtensionCodec时应按内容特征解析:%+v %v", db, err)
+	}
+	if err := conf.Clazz("base", "db", "", "client.conf", &db, configuration.WithExtensionCodec()); err == nil || !strings.Contains(err.Error(), "ini") {
+		t.Error("开启WithExtensionCodec时应按后缀使用INI解析:", err)
+	}
+	// 以[开头且不是[section]的数据按JSON解析
+	if err := conf.Clazz("base", "db", "", "broken-array", &m); err == nil || !strings.Contains(err.Error(), "json") {
+		t.Error("以[开头的非法JSON应按JSON解析并返回错误:", err)
+	}
+}
+
+type level int
+
+func (l *level) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "debug":
+		*l = 1
+	case "info":
+		*l = 2
+	default:
+		return fmt.Errorf("未知的日志级别:%s", text)
+	}
+	return nil
+}
+
+type appConfig struct {
+	Name string `config:"name" default:"demo"`
+	DB   struct {
+		Host    string        `config:"host" default:"localhost"`
+		Port    uint16        `config:"port" default:"3306"`
+		Timeout time.Duration `config:"timeout"`
+		Replica *bool         `config:"replica"`
+	} `config:"db"`
+	Cache struct {
+		Nodes   []string       `config:"cache/nodes"`
+		Weights map[string]int `config:"cache/weights"`
+		Ratio   float64        `config:"cache/ratio"`
+	}
+	Level  level    `config:"log/level"`
+	Server test     `config:"server"`
+	Ports  []int    `config:"ports"`
+	Ignore string   `config:"-"`
+	Other  []string // 未绑定
+}
+
+func TestUnmarshal(t *testing.T) {
+	conf := configuration.MockEngine(t, backends.StoreConfig{Exp: map[string]string{
+		"/system/base/app/db/host":       "db.local",
+		"/system/base/app/db/timeout":    "5s",
+		"/system/base/app/db/replica":    "true",
+		"/system/base/app/cache/nodes":   "a:1, b:2",
+		"/system/base/app/cache/weights": `{"a":1,"b":2}`,
+		"/system/base/app/cache/ratio":   "0.5",
+		"/system/base/app/log/level":     "info",
+		"/system/base/app/server":        `{"key":"k","value":"v"}`,
+		"/system/base/app/ports":         "[80,443]",
+	}})
+	var cfg appConfig
+	cfg.Other = []string{"keep"}
+	if err := conf.Unmarshal("base", "app", "", &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Name != "demo" || cfg.DB.Host != "db.local" || cfg.DB.Port != 3306 || cfg.DB.Timeout != 5*time.Second || cfg.DB.Replica == nil || !*cfg.DB.Replica {
+		t.Errorf("结果不匹配:%+v", cfg)
+	}
+	if !reflect.DeepEqual(cfg.Cache.Nodes, []string{"a:1", "b:2"}) || cfg.Cache.Weights["b"] != 2 || cfg.Cache.Ratio != 0.5 {
+		t.Errorf("结果不匹配:%+v", cfg.Cache)
+	}
+	if cfg.Level != 2 || cfg.Server.Value != "v" || !reflect.DeepEqual(cfg.Ports, []int{80, 443}) || cfg.Other[0] != "keep" {
+		t.Errorf("结果不匹配:%+v", cfg)
+	}
+
+	conf.Modify("base", "app", "", "db/timeout", []byte("soon"))
+	err := conf.Unmarshal("base", "app", "", &cfg)
+	if err == nil || !strings.Contains(err.Error(), "/system/base/app/db/timeout") {
+		t.Error("转换失败的错误应包含配置项的完整路径:", err)
+	}
+	if err = conf.Unmarshal("base", "app", "", cfg); err == nil {
+		t.Error("目标不是结构体指针时应返回错误")
+	}
+
+	// 出错时不应通过嵌套的结构体指针修改原值
+	type dbSection struct {
+		Host    string        `config:"host"`
+		Timeout time.Duration `config:"timeout"`
+	}
+	type pointerConfig struct {
+		DB *dbSection `config:"db"`
+	}
+	pc := pointerConfig{DB: &dbSection{Host: "old"}}
+	db := pc.DB
+	if err = conf.Unmarshal("base", "app", "", &pc); err == nil {
+		t.Error("转换失败时应返回错误")
+	}
+	if pc.DB != db || db.Host != "old" {
+		t.Errorf("出错时应保留原值:%+v", *db)
+	}
+
+	// 未加config标签的指针保持原样，自引用的结构体不会无限递归
+	type conn struct {
+		sync.Mutex
+		Name string
+	}
+	type selfConfig struct {
+		Host string `config:"db/host"`
+		Conn *conn
+		Self *selfConfig
+	}
+	c := &conn{Name: "primary"}
+	sc := selfConfig{Conn: c}
+	sc.Self = &sc
+	if err = conf.Unmarshal("base", "app", "", &sc); err != nil {
+		t.Fatal(err)
+	}
+	if sc.Host != "db.local" || sc.Conn != c || sc.Self != &sc {
+		t.Errorf("未加标签的指针应保持原样:%+v", sc)
+	}
+}
+
+type poolConfig struct {
+	Name    string        `json:"name" validate:"required,regex=^[a-z]+(,[a-z]+)*$"`
+	Size    int           `json:"size" validate:"min=1,max=100"`
+	Mode    string        `json:"mode" validate:"oneof=fifo lifo"`
+	Idle    time.Duration `json:"idle" validate:"max=1m"`
+	Servers []string      `json:"servers" validate:"required,min=1"`
+}
+
+func (p poolConfig) Validate() error {
+	if p.Mode == "lifo" && p.Size > 10 {
+		return errors.New("lifo模式下size不能超过10")
+	}
+	return nil
+}
+
+func TestValidate(t *testing.T) {
+	valid := `{"name":"a,b","size":5,"mode":"lifo","idle":1000000000,"servers":["s1"]}`
+	conf := configuration.MockEngine(t, backends.StoreConfig{Exp: map[string]string{
+		"/system/base/db/pool": valid,
+	}})
+	var pool poolConfig
+	if err := conf.Clazz("base", "db", "", "pool", &pool); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		data string
+		rule string
+	}{
+		{`{"name":"","size":5,"mode":"fifo","servers":["s1"]}`, "required"},
+		{`{"name":"A","size":5,"mode":"fifo","servers":["s1"]}`, "regex"},
+		{`{"name":"a","size":-1,"mode":"fifo","servers":["s1"]}`, "min=1"},
+		{`{"name":"a","size":101,"mode":"fifo","servers":["s1"]}`, "max=100"},
+		{`{"name":"a","size":5,"mode":"random","servers":["s1"]}`, "oneof"},
+		{`{"name":"a","size":5,"mode":"fifo","idle":120000000000,"servers":["s1"]}`, "max=1m"},
+		{`{"name":"a","size":5,"mode":"fifo","servers":[]}`, "min=1"},
+		{`{"name":"a","size":20,"mode":"lifo","servers":["s1"]}`, "lifo"},
+	} {
+		conf.Modify("base", "db", "", "pool", []byte(c.data))
+		err := conf.Clazz("base", "db", "", "pool", &pool)
+		if err == nil || !strings.Contains(err.Error(), c.rule) || !strings.Contains(err.Error(), "/system/base/db/pool") {
+			t.Errorf("%s应校验失败:%v", c.data, err)
+		}
+		if pool.Size != 5 || pool.Name != "a,b" {
+			t.Errorf("校验失败时应保留原值:%+v", pool)
+		}
+	}
+
+	conf.Modify("base", "db", "", "pool", []byte(valid))
+	v, err := configuration.Bind[poolConfig](conf, "base", "db", "", "pool", configuration.WithValidator(func(v interface{}) error {
+		if v.(*poolConfig).Servers[0] == "forbidden" {
+			return errors.New("禁止使用的服务器")
+		}
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	rejected, changed := make(chan error, 1), make(chan poolConfig, 1)
+	v.OnReject(func(err error) { rejected <- err })
+	v.OnChange(func(old, new poolConfig) { changed <- new })
+	conf.Modify("base", "db", "", "pool", []byte(`{"name":"a","size":0,"mode":"fifo","servers":["s1"]}`))
+	select {
+	case err = <-rejected:
+		var errs configuration.ValidationErrors
+		if !errors.As(err, &errs) || errs[0].Field != "Size" {
+			t.Error("应报告不满足规则的字段:", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("未收到拒绝通知")
+	}
+	if v.Load().Size != 5 {
+		t.Error("校验失败时应保留上一次的值:", v.Load())
+	}
+	conf.Modify("base", "db", "", "pool", []byte(`{"name":"a","size":1,"mode":"fifo","servers":["forbidden"]}`))
+	if err = <-rejected; !strings.Contains(err.Error(), "禁止使用的服务器") {
+		t.Error("应执行WithValidator的校验:", err)
+	}
+	conf.Modify("base", "db", "", "pool", []byte(`{"name":"a","size":2,"mode":"fifo","servers":["s2"]}`))
+	if n := <-changed; n.Size != 2 {
+		t.Error("合法的配置应被应用:", n)
+	}
+
+	// 嵌套的指针、切片和map在校验失败时同样保持原值
+	type sub struct {
+		Size int `json:"size" validate:"min=1"`
+	}
+	type nestedConfig struct {
+		Pool   *sub              `json:"pool"`
+		Hosts  []string          `json:"hosts"`
+		Labels map[string]string `json:"labels"`
+	}
+	conf.Add("base", "db", "", "nested", []byte(`{"pool":{"size":-5},"hosts":["b"],"labels":{"env":"dev"}}`), 0)
+	nc := nestedConfig{Pool: &sub{Size: 3}, Hosts: []string{"a"}, Labels: map[string]string{"env": "prod"}}
+	pool2, hosts, labels := nc.Pool, nc.Hosts, nc.Labels
+	if err = conf.Clazz("base", "db", "", "nested", &nc); err == nil {
+		t.Error("嵌套字段应校验失败")
+	}
+	if nc.Pool != pool2 || pool2.Size != 3 || hosts[0] != "a" || labels["env"] != "prod" {
+		t.Errorf("校验失败时不应修改嵌套的字段:%+v %+v", nc, *pool2)
+	}
+
+	// 未加标签的指针(如含锁的共享对象)保持原样，循环引用的指针不会无限递归
+	type shared struct {
+		sync.Mutex
+		Name string
+	}
+	type node struct {
+		Size int   `json:"size" validate:"min=1"`
+		Next *node `json:"next"`
+	}
+	type resourceConfig struct {
+		Shared *shared
+		Node   *node `json:"node"`
+	}
+	conf.Add("base", "db", "", "resource", []byte(`{"node":{"size":2}}`), 0)
+	sh, n := &shared{Name: "db"}, &node{Size: 1}
+	n.Next = n
+	rc := resourceConfig{Shared: sh, Node: n}
+	if err = conf.Clazz("base", "db", "", "resource", &rc); err != nil {
+		t.Fatal(err)
+	}
+	if rc.Shared != sh || rc.Node.Size != 2 || rc.Node.Next != rc.Node || n.Size != 1 {
+		t.Errorf("未加标签的指针应保持原样，循环引用应指向拷贝:%+v %+v", rc, *rc.Node)
+	}
+
+	type tagged struct {
+		Port int `config:"port" validate:"min=1024"`
+	}
+	conf.Add("base", "db", "", "port", []byte("80"), 0)
+	cfg := tagged{Port: 8080}
+	if err = conf.Unmarshal("base", "db", "", &cfg); err == nil || cfg.Port != 8080 {
+		t.Error("Unmarshal校验失败时应返回错误并保留原值:", err, cfg)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	conf := configuration.MockEngine(t, backends.StoreConfig{Exp: map[string]string{"/system/base/db": "", "/system/base/cache": ""}})
+	schema := []byte(`{
+		"type": "object",
+		"required": ["host", "port"],
+		"properties": {
+			"host": {"type": "string", "minLength": 1},
+			"port": {"type": "integer", "minimum": 1, "maximum": 65535}
+		}
+	}`)
+	if err := conf.RegisterSchema("base", "db", []byte(`{"type": 1}`)); err == nil {
+		t.Error("不合法的schema应注册失败")
+	}
+	if err := conf.RegisterSchema("base", "db", schema); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := conf.Schema("base", "db"); err != nil || !bytes.Equal(s, schema) {
+		t.Error("获取的schema不匹配:", err)
+	}
+	if _, err := conf.Add("base", "db", "", "main", []byte(`{"host":"a","port":3306}`), 0); err != nil {
+		t.Fatal(err)
+	}
+	err := conf.Modify("base", "db", "", "main", []byte(`{"host":"","port":70000}`))
+	var se *configuration.SchemaError
+	if !errors.As(err, &se) || se.Path != "/system/base/db/main" || len(se.Errors) != 2 ||
+		!strings.HasPrefix(se.Errors[0], "/host") && !strings.HasPrefix(se.Errors[1], "/host") {
+		t.Fatalf("应返回详细的校验错误:%v", err)
+	}
+	if _, err = conf.Add("base", "db", "", "broken", []byte(`{"host":`), 0); !errors.As(err, &se) {
+		t.Error("格式错误的配置应被拒绝:", err)
+	}
+	if _, err = conf.Add("base", "db", "", "main.yaml", []byte("host: a\nport: 1\n"), 0); err != nil {
+		t.Error("YAML格式的配置应按schema校验:", err)
+	}
+	// 与schema顶层type不一致的配置项(如标量)不校验
+	for _, scalar := range []string{"3s", "3306", "true"} {
+		if _, err = conf.Add("base", "db", "", "timeout-"+scalar, []byte(scalar), 0); err != nil {
+			t.Error("与schema的type不一致的配置项不应校验:", scalar, err)
+		}
+	}
+	if _, err = conf.Add("base", "db", "", "partial", []byte(`{"host":"a"}`), 0); !errors.As(err, &se) {
+		t.Error("与schema的type一致的配置项应校验:", err)
+	}
+	if _, err = conf.Add("base", "cache", "", "main", []byte(`not json`), 0); err != nil {
+		t.Error("未注册schema的分组不应校验:", err)
+	}
+	if err = configuration.ValidateSchema(schema, "db.toml", []byte("host = \"a\"\nport = 0\n")); !errors.As(err, &se) {
+		t.Error("应按文件格式解码后校验:", err)
+	}
+	if err = conf.DeleteSchema("base", "db"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conf.Schema("base", "db"); !errors.Is(err, backends.ErrNoNode) {
+		t.Error("删除后获取schema应返回ErrNoNode:", err)
+	}
+	if err = conf.Modify("base", "db", "", "main", []byte(`{}`)); err != nil {
+		t.Error("删除schema后不应再校验:", err)
+	}
+}
+
+func TestLayeredLookup(t *testing.T) {
+	data := map[string]string{
+		"/system/base/db/prod/host":  "prod.db",
+		"/system/base/db/host":       "localhost",
+		"/system/base/db/port":       "3306",
+		"/system/base/common/region": "cn",
+		"/system/base/common/port":   "1",
+	}
+	plain := configuration.MockEngine(t, backends.StoreConfig{Exp: data})
+	if _, err := plain.String("base", "db", "prod", "port"); !errors.Is(err, backends.ErrNoNode) {
+		t.Error("未开启分层查找时tag不应回退:", err)
+	}
+
+	conf := configuration.MockEngine(t, backends.StoreConfig{Exp: data}, configuration.WithCommonGroup("common"))
+	for path, expected := range map[string]string{"host": "prod.db", "port": "3306", "region": "cn"} {
+		if v, err := conf.String("base", "db", "prod", path); v != expected || err != nil {
+			t.Error("分层查找的结果不匹配\n", "预期:", expected, "|", "实际:", v, err)
+		}
+	}
+	r, err := conf.Resolve("base", "db", "prod", []string{"host", "port", "region", "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources := map[string]string{
+		"/system/base/db/prod/host":   "/system/base/db/prod/host",
+		"/system/base/db/prod/port":   "/system/base/db/port",
+		"/system/base/db/prod/region": "/system/base/common/region",
+	}
+	if len(r) != len(sources) {
+		t.Errorf("不存在的配置项不应在结果中:%+v", r)
+	}
+	for k, s := range sources {
+		if r[k].Source != s {
+			t.Error("配置来源不匹配\n", "预期:", s, "|", "实际:", r[k].Source)
+		}
+	}
+	if vl, _ := conf.Values("base", "db", "prod", []string{"port"}); vl["/system/base/db/prod/port"] != "3306" {
+		t.Error("Values应返回回退的配置:", vl)
+	}
+	if port, _ := conf.Int("base", "db", "", "port"); port != 3306 {
+		t.Error("组内的配置应优先于公共配置:", port)
+	}
+	_, err = conf.String("base", "db", "prod", "missing")
+	if !errors.Is(err, backends.ErrNoNode) || !strings.Contains(err.Error(), "/system/base/common/missing") {
+		t.Error("不存在时应列出所有查找过的路径:", err)
+	}
+
+	host, err := configuration.Bind[string](conf, "base", "db", "prod", "port")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer host.Close()
+	changed := make(chan string, 1)
+	host.OnChange(func(old, new string) { changed <- new })
+	conf.Add("base", "db", "prod", "port", []byte("3307"), 0)
+	select {
+	case v := <-changed:
+		if v != "3307" {
+			t.Error("新增的tag配置应覆盖默认配置:", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("未收到配置变化通知")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	conf := configuration.MockEngine(t, backends.StoreConfig{Exp: map[string]string{
+		"/system/base/common/db":      `{"pool":{"size":10,"idle":2},"hosts":["c"]}`,
+		"/system/base/common/db.yaml": "pool: {idle: 1}",
+		"/system/base/db/db.yaml":     "host: base\npool:\n  size: 20\nhosts: [b]\n",
+		"/system/base/db/db":          `{"host":"base","pool":{"size":20},"hosts":["b"]}`,
+		"/system/base/db/prod/db":     `{"pool":{"size":50},"hosts":["p"]}`,
+		"/system/base/db/host":        "localhost",
+		"/system/base/db/prod/host":   "db.prod",
+	}}, configuration.WithCommonGroup("common"), configuration.WithMergeStrategy(configuration.MergeDeep))
+	type config struct {
+		Host string `json:"host"`
+		Pool struct {
+			Size int `json:"size"`
+			Idle int `json:"idle"`
+		} `json:"pool"`
+		Hosts []string `json:"hosts"`
+	}
+	var c config
+	if err := conf.Clazz("base", "db", "prod", "db", &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Host != "base" || c.Pool.Size != 50 || c.Pool.Idle != 2 || !reflect.DeepEqual(c.Hosts, []string{"p"}) {
+		t.Errorf("深度合并的结果不匹配:%+v", c)
+	}
+	c = config{}
+	conf.Clazz("base", "db", "prod", "db", &c, configuration.WithMerge(configuration.MergeAppend))
+	if c.Pool.Size != 50 || !reflect.DeepEqual(c.Hosts, []string{"c", "b", "p"}) {
+		t.Errorf("拼接数组的结果不匹配:%+v", c)
+	}
+	c = config{}
+	conf.Clazz("base", "db", "prod", "db", &c, configuration.WithMerge(configuration.MergeReplace))
+	if c.Host != "" || c.Pool.Idle != 0 || c.Pool.Size != 50 {
+		t.Errorf("replace应只使用优先级最高的配置:%+v", c)
+	}
+	c = config{}
+	if err := conf.Clazz("base", "db", "", "db.yaml", &c, configuration.WithCodec(configuration.TOMLCodec{})); err == nil {
+		t.Error("指定的解码器应用于每一层")
+	}
+	conf.Modify("base", "common", "", "db.yaml", []byte("pool: {idle: 3}"))
+	if err := conf.Clazz("base", "db", "", "db.yaml", &c); err != nil || c.Host != "base" || c.Pool.Size != 20 || c.Pool.Idle != 3 {
+		t.Errorf("不同格式的层应分别解码后合并:%+v %v", c, err)
+	}
+
+	// 标量配置项不合并，使用优先级最高的原始数据
+	host, err := configuration.Bind[string](conf, "base", "db", "prod", "host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host.Close()
+	if s, _ := conf.String("base", "db", "prod", "host"); host.Load() != "db.prod" || s != host.Load() {
+		t.Error("标量配置项应使用优先级最高的原始数据:", host.Load())
+	}
+
+	v, err := configuration.Bind[config](conf, "base", "db", "prod", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	changed := make(chan config, 1)
+	v.OnChange(func(old, new config) { changed <- new })
+	conf.Modify("base", "common", "", "db", []byte(`{"pool":{"idle":5}}`))
+	select {
+	case n := <-changed:
+		if n.Pool.Idle != 5 || n.Pool.Size != 50 || n.Host != "base" {
+			t.Errorf("任意一层变化后应重新合并:%+v", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("未收到配置变化通知")
+	}
+}
+
+func TestInterpolation(t *testing.T) {
+	os.Setenv("CONFIGURATION_TEST_DB_USER", "root")
+	defer os.Unsetenv("CONFIGURATION_TEST_DB_USER")
+	data := map[string]string{
+		"/system/base/common/host": "db.internal",
+		"/system/base/common/port": "3306",
+		"/system/base/common/addr": "${base/common/host}:${base/common/port}",
+		"/system/base/db/dsn":      "${env:CONFIGURATION_TEST_DB_USER}@tcp(${base/common/addr})/app?timeout=${base/common/timeout:-5s}&cost=$$1",
+		"/system/base/db/conf":     `{"host":"${base/common/host}","port":${base/common/port}}`,
+		"/system/base/db/a":        "${base/db/b}",
+		"/system/base/db/b":        "${base/db/a}",
+		"/system/base/db/missing":  "${base/db/nothing}",
+	}
+	plain := configuration.MockEngine(t, backends.StoreConfig{Exp: data})
+	if v, _ := plain.String("base", "db", "", "dsn"); !strings.HasPrefix(v, "${env:") {
+		t.Error("未开启变量插值时应返回原始数据:", v)
+	}
+
+	conf := configuration.MockEngine(t, backends.StoreConfig{Exp: data}, configuration.WithInterpolation())
+	expected := "root@tcp(db.internal:3306)/app?timeout=5s&cost=$1"
+	if v, err := conf.String("base", "db", "", "dsn"); v != expected || err != nil {
+		t.Error("插值的结果不匹配\n", "预期:", expected, "|", "实际:", v, err)
+	}
+	var c dbConfig
+	if err := conf.Clazz("base", "db", "", "conf", &c); err != nil || c.Host != "db.internal" || c.Port != 3306 {
+		t.Errorf("Clazz应解码插值后的配置:%+v %v", c, err)
+	}
+	if _, err := conf.String("base", "db", "", "a"); err == nil || !strings.Contains(err.Error(), "循环引用") {
+		t.Error("循环引用应返回错误:", err)
+	}
+	if _, err := conf.String("base", "db", "", "missing"); !errors.Is(err, backends.ErrNoNode) {
+		t.Error("引用不存在且没有默认值时应返回ErrNoNode:", err)
+	}
+
+	// 只展开分层查找选中的层，被覆盖的层中无法解析的引用不影响读取
+	data["/system/base/db/prod/url"] = "${base/common/host}"
+	data["/system/base/db/url"] = "${env:CONFIGURATION_TEST_UNSET}"
+	layered := configuration.MockEngine(t, backends.StoreConfig{Exp: data}, configuration.WithInterpolation(), configuration.WithLayeredLookup())
+	if v, err := layered.String("base", "db", "prod", "url"); v != "db.internal" || err != nil {
+		t.Error("被覆盖的层不应被展开:", v, err)
+	}
+	if _, err := layered.String("base", "db", "", "url"); err == nil {
+		t.Error("选中的层引用不存在的环境变量时应返回错误")
+	}
+	if v, err := configuration.Bind[string](layered, "base", "db", "prod", "url"); err != nil {
+		t.Error("Bind不应展开被覆盖的层:", err)
+	} else {
+		v.Close()
+	}
+
+	v, err := configuration.Bind[string](conf, "base", "db", "", "dsn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	changed := make(chan string, 1)
+	v.OnChange(func(old, new string) { changed <- new })
+	conf.Modify("base", "common", "", "host", []byte("db2.internal"))
+	select {
+	case n := <-changed:
+		if n != "root@tcp(db2.internal:3306)/app?timeout=5s&cost=$1" {
+			t.Error("被引用的配置项变化后的结果不匹配:", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("被引用的配置项变化时未收到通知")
+	}
+	// 新增的引用同样被监听
+	conf.Add("base", "common", "", "timeout", []byte("10s"), 0)
+	select {
+	case n := <-changed:
+		if !strings.Contains(n, "timeout=10s") {
+			t.Error("新增被引用的配置项后的结果不匹配:", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("新增被引用的配置项时未收到通知")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	file := t.TempDir() + "/configuration.snapshot"
+	key := []byte("0123456789abcdef0123456789abcdef")
+	conf := configuration.MockEngine(t, backends.StoreConfig{Exp: map[string]string{
+		"/system/base/db/host": "db.internal",
+		"/system/base/db/port": "3306",
+	}}, configuration.WithSnapshot(file, key))
+	if v, err := conf.String("base", "db", "", "host"); v != "db.internal" || err != nil || conf.Stale() {
+		t.Fatal("配置中心可用时应从配置中心读取:", v, err)
+	}
+	conf.Int("base", "db", "", "port")
+	if b, err := os.ReadFile(file); err != nil || bytes.Contains(b, []byte("db.internal")) {
+		t.Fatal("快照文件应加密保存:", string(b), err)
+	}
+
+	// 配置中心不可用时从快照读取
+	offline := configuration.Engine(backends.StoreConfig{Backend: "127.0.0.1:1"}, configuration.WithSnapshot(file, key))
+	if v, err := offline.String("base", "db", "", "host"); v != "db.internal" || err != nil {
+		t.Error("配置中心不可用时应从快照读取:", v, err)
+	}
+	if port, _ := offline.Int("base", "db", "", "port"); port != 3306 || !offline.Stale() {
+		t.Error("从快照读取时Stale应为true:", port, offline.Stale())
+	}
+	if err := offline.Modify("base", "db", "", "host", []byte("x")); !errors.Is(err, configuration.ErrReadOnly) {
+		t.Error("降级模式下写操作应返回ErrReadOnly:", err)
+	}
+	if _, err := offline.String("base", "db", "", "missing"); err == nil {
+		t.Error("快照中不存在的配置项应返回错误")
+	}
+	// 启动时连接配置中心失败，Watch应重试而不是panic或返回
+	unconnected := configuration.Engine(backends.StoreConfig{}, configuration.WithSnapshot(file, key))
+	if v, _ := unconnected.String("base", "db", "", "host"); v != "db.internal" {
+		t.Error("连接失败时应从快照读取:", v)
+	}
+	watched := make(chan struct{})
+	go func() {
+		defer close(watched)
+		unconnected.Watch("base", "db", "", "servers", new(Server))
+	}()
+	select {
+	case <-watched:
+		t.Error("降级模式下Watch应重试直到配置中心恢复")
+	case <-time.After(time.Second):
+	}
+
+	// 密钥不正确或文件被篡改时不使用快照
+	wrong := configuration.Engine(backends.StoreConfig{}, configuration.WithSnapshot(file, []byte("fedcba9876543210")))
+	if _, err := wrong.String("base", "db", "", "host"); err == nil {
+		t.Error("密钥不正确时不应读取快照")
+	}
+	b, _ := os.ReadFile(file)
+	b[len(b)/2] ^= 1
+	os.WriteFile(file, b, 0600)
+	tampered := configuration.Engine(backends.StoreConfig{}, configuration.WithSnapshot(file, key))
+	if _, err := tampered.String("base", "db", "", "host"); err == nil {
+		t.Error("文件被篡改时不应读取快照")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("密钥长度不正确时应panic")
+		}
+	}()
+	configuration.Engine(backends.StoreConfig{}, configuration.WithSnapshot(file, []byte("short")))
+}
+
+func TestCache(t *testing.T) {
+	conf := configuration.MockEngine(t, backends.StoreConfig{Exp: map[string]string{
+		"/system/base/db/host": "db.internal",
+		"/system/base/db/port": "3306",
+		"/system/base/db/user": "root",
+	}}, configuration.WithCache(2))
+	for i := 0; i < 3; i++ {
+		if v, err := conf.String("base", "db", "", "host"); v != "db.internal" || err != nil {
+			t.Fatal("读取缓存的结果不匹配:", v, err)
+		}
+	}
+	if s := conf.CacheStats(); s.Hits != 2 || s.Misses != 1 || s.Entries != 1 {
+		t.Errorf("缓存统计不匹配:%+v", s)
+	}
+	conf.Modify("base", "db", "", "host", []byte("db2.internal"))
+	if v, _ := conf.String("base", "db", "", "host"); v != "db2.internal" {
+		t.Error("配置项变化后缓存应失效:", v)
+	}
+
+	// 不存在的配置项同样缓存，创建后失效
+	if _, err := conf.String("base", "db", "", "timeout"); !errors.Is(err, backends.ErrNoNode) {
+		t.Error("配置项不存在时应返回ErrNoNode:", err)
+	}
+	conf.Add("base", "db", "", "timeout", []byte("3s"), 0)
+	if v, _ := conf.Duration("base", "db", "", "timeout"); v != 3*time.Second {
+		t.Error("配置项创建后缓存应失效:", v)
+	}
+
+	conf.Values("base", "db", "", []string{"port", "user"})
+	if s := conf.CacheStats(); s.Entries != 2 || s.Evictions == 0 {
+		t.Errorf("超出容量时应淘汰最久未使用的配置项:%+v", s)
+	}
+
+	v, err := configuration.Bind[int](conf, "base", "db", "", "port")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	changed := make(chan int, 1)
+	v.OnChange(func(old, new int) { changed <- new })
+	conf.Modify("base", "db", "", "port", []byte("3307"))
+	select {
+	case n := <-changed:
+		if n != 3307 {
+			t.Error("Bind应读取到变化后的数据:", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("未收到配置变化通知")
+	}
+}
+
+func TestZookeeperClient(t *testing.T) {
+	store, dir := zookeeperStore(t, "host")
+	if err := store.Modify(dir+"/host", []byte("db.internal")); err != nil {
+		t.Fatal("Modify出错:", err)
+	}
+	if b, _, err := store.Get(dir + "/host"); err != nil || string(b) != "db.internal" {
+		t.Error("Modify后读取的结果不匹配:", string(b), err)
+	}
+	if err := store.Delete(dir + "/host"); err != nil {
+		t.Fatal("Delete出错:", err)
+	}
+	if ok, err := store.Exists(dir + "/host"); ok || err != nil {
+		t.Error("Delete后节点不应存在:", ok, err)
+	}
+	if err := store.Modify(dir+"/host", []byte("x")); !errors.Is(err, backends.ErrNoNode) {
+		t.Error("修改不存在的节点应返回ErrNoNode:", err)
+	}
+}
+
+// TestZookeeperClientErrors 只覆盖配置中心不可用时的错误，正常的Modify/Delete见TestZookeeperClient。
+func TestZookeeperClientErrors(t *testing.T) {
+	if _, err := backends.New(backends.StoreConfig{Backend: ""}); err == nil {
+		t.Error("服务器列表不正确时应返回错误而不是panic")
+	}
+	store, err := backends.New(backends.StoreConfig{Backend: "127.0.0.1:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err = store.Modify("/system/base/db/host", []byte("x")); err == nil {
+		t.Error("配置中心不可用时Modify应返回错误")
+	}
+	if err = store.Delete("/system/base/db/host"); err == nil {
+		t.Error("配置中心不可用时Delete应返回错误")
+	}
+}
+
+// zookeeperStore 连接环境变量UAF配置的配置中心，并在/system/test下创建本次测试专用的节点，测试结束后删除。
+func zookeeperStore(t *testing.T, names ...string) (backends.StoreClient, string) {
+	if len(os.Getenv("UAF")) == 0 {
+		t.Skip("需要在环境变量UAF中配置可用的配置中心")
+	}
+	store, err := backends.New(configuration.NewStoreConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := fmt.Sprintf("/system/test/%s-%d", strings.ToLower(t.Name()), time.Now().UnixNano())
+	for _, p := range []string{"/system/test", dir} {
+		if _, err = store.Add(p, nil, 0); err != nil && !errors.Is(err, backends.ErrNodeExists) {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range names {
+		if _, err = store.Add(dir+"/"+name, []byte("v"), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		children, _ := store.Children(dir)
+		for _, c := range children {
+			store.Delete(dir + "/" + c)
+		}
+		store.Delete(dir)
+		store.Close()
+	})
+	return store, dir
+}
+
+func TestZookeeperWatchPrefix(t *testing.T) {
+	store, dir := zookeeperStore(t, "k0", "k1", "k2")
+	keys := []string{dir + "/k0", dir + "/k1", dir + "/k2"}
+	type result struct {
+		index uint64
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		index, err := store.WatchPrefix(keys, 1, make(chan bool))
+		done <- result{index, err}
+	}()
+	// 只修改最后一个key，监听建立之前的修改不会触发，因此重复修改直到WatchPrefix返回
+	for i := 0; ; i++ {
+		store.Modify(keys[2], []byte(fmt.Sprint(i)))
+		select {
+		case r := <-done:
+			if r.err != nil || r.index == 0 {
+				t.Error("最后一个key变化时WatchPrefix的结果不匹配:", r.index, r.err)
+			}
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+		if i == 50 {
+			t.Fatal("最后一个key变化时WatchPrefix未返回")
+		}
+	}
+}
+
+// TestZookeeperWatchPrefixErrors 只覆盖配置中心不可用时的错误和监听协程的退出，每个key都被监听见TestZookeeperWatchPrefix。
+func TestZookeeperWatchPrefixErrors(t *testing.T) {
+	store, err := backends.New(backends.StoreConfig{Backend: "127.0.0.1:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	baseline := runtime.NumGoroutine()
+	keys := []string{"/system/base/db/host", "/system/base/db/port", "/system/base/db/user"}
+	if _, err = store.WatchPrefix(keys, 1, make(chan bool)); err == nil {
+		t.Error("配置中心不可用时WatchPrefix应返回错误")
+	}
+	// 每个key都有监听协程，WatchPrefix返回后其余协程的结果写入缓冲后退出，不会泄漏
+	for i := 0; runtime.NumGoroutine() > baseline; i++ {
+		if i == 100 {
+			t.Fatal("WatchPrefix返回后监听协程未退出:", runtime.NumGoroutine()-baseline)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
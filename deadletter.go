@@ -0,0 +1,114 @@
+package configuration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aluka-7/configuration/backends"
+)
+
+// DeadLetter 重试耗尽仍处理失败的事件，保存在/system_events/_dead/<key>目录下以便排查和手工重放。
+type DeadLetter struct {
+	Event       Event  `json:"event"`        // 处理失败的事件
+	EventOffset int64  `json:"event_offset"` // 事件原来的offset
+	Error       string `json:"error"`        // 最后一次处理失败的错误信息
+	Attempts    int    `json:"attempts"`     // 处理次数(含重试)
+	FailedAt    int64  `json:"failed_at"`    // 最后一次失败的时间，单位毫秒
+	// Target 处理失败的订阅：消费组订阅为group:<消费组名>，带检查点的订阅为checkpoint:<检查点名称>，
+	// 其他订阅为name:<订阅名称>(见WithName)，重放时只投递给该订阅
+	Target string `json:"target,omitempty"`
+	Offset int64  `json:"-"` // 死信在其key下的顺序号
+}
+
+func (ee *eventEngine) deadLetterPath(key string) string {
+	return ee.eventPath + "/" + deadLetterDir + "/" + key
+}
+
+func (ee *eventEngine) deadLetter(e Event, target string, cause error, attempts int) error {
+	b, err := json.Marshal(DeadLetter{Event: e, EventOffset: e.Offset, Error: cause.Error(), Attempts: attempts, FailedAt: time.Now().UnixMilli(), Target: target})
+	if err != nil {
+		return err
+	}
+	dir := ee.deadLetterPath(e.Key)
+	if err = ensurePath(ee.store, dir); err != nil {
+		return err
+	}
+	_, err = ee.store.Add(dir+"/"+eventNodePrefix, b, backends.FlagSequence)
+	return err
+}
+
+// DeadLetters 获取指定事件key下所有的死信，按死信的顺序号排列。
+func (ee *eventEngine) DeadLetters(key string) ([]DeadLetter, error) {
+	dir := ee.deadLetterPath(key)
+	children, err := ee.store.Children(dir)
+	if err == backends.ErrNoNode {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	nodes := sortSeq(children)
+	paths := make([]string, len(nodes))
+	for i, n := range nodes {
+		paths[i] = dir + "/" + n.name
+	}
+	vl, err := ee.store.GetValues(paths)
+	if err != nil {
+		return nil, err
+	}
+	letters := make([]DeadLetter, 0, len(nodes))
+	for i, n := range nodes {
+		v, ok := vl[paths[i]]
+		if !ok {
+			// 已被重新发布或删除
+			continue
+		}
+		var dl DeadLetter
+		if err = json.Unmarshal([]byte(v), &dl); err != nil {
+			return nil, fmt.Errorf("解析死信[%s]出错:%w", paths[i], err)
+		}
+		dl.Offset = n.seq
+		letters = append(letters, dl)
+	}
+	return letters, nil
+}
+
+// ReplayDeadLetter 将指定的死信作为新事件重新发布，只投递给处理失败的订阅(DeadLetter.Target)，
+// 已成功处理该事件的其他订阅不会重复收到。死信在目标订阅处理完成(成功，或再次失败并写入新的死信)后由该订阅删除，
+// 当前没有匹配的订阅时死信保留，可在订阅恢复后再次重放。
+func (ee *eventEngine) ReplayDeadLetter(key string, offset int64) error {
+	path := ee.deadLetterNode(key, offset)
+	vl, err := ee.store.GetValues([]string{path})
+	if err != nil {
+		return err
+	}
+	v, ok := vl[path]
+	if !ok {
+		return backends.ErrNoNode
+	}
+	var dl DeadLetter
+	if err = json.Unmarshal([]byte(v), &dl); err != nil {
+		return fmt.Errorf("解析死信[%s]出错:%w", path, err)
+	}
+	e, err := NewFCEventTimeout(dl.Event.Key, dl.Event.Timeout)
+	if err != nil {
+		return err
+	}
+	if dl.Event.Body != nil {
+		e.Body = dl.Event.Body
+	}
+	e.Target, e.DeadLetter = dl.Target, offset
+	// 重新发布的事件沿用原事件的trace
+	return ee.PublishContext(ContextWithTraceParent(context.Background(), dl.Event.TraceParent), e)
+}
+
+// DeleteDeadLetter 删除指定的死信。
+func (ee *eventEngine) DeleteDeadLetter(key string, offset int64) error {
+	return ee.store.Delete(ee.deadLetterNode(key, offset))
+}
+
+func (ee *eventEngine) deadLetterNode(key string, offset int64) string {
+	return fmt.Sprintf("%s/%s%010d", ee.deadLetterPath(key), eventNodePrefix, offset)
+}
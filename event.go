@@ -1,26 +1,129 @@
 package configuration
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"regexp"
 	"time"
 
-	"github.com/aluka-7/configuration/backends"
+	"github.com/rs/zerolog/log"
 )
 
 // Event 系统之间的准实时通知事件对象，封装需要发布的事件信息。
 // 需要特别注意的是，每个事件的key必须是全局唯一的，不仅仅是系统内唯一，而是整个系统生态内唯一。
 // 另外，每个时间会被赋予一个超时时间（默认是10s），当事件被发布时会被设置一个发布时间，接收端接收到事件后会根据其系统本地时间和发布时间进行对
-// 比，如果差额超过了超时时间则认为事件超时了则事件监听程序不会被执行，反之事件程序会得到执行。
-// 每个事件允许携带一定量的数据，携带的数据总量以字节长度计算(JSON格式化后的)，限制最大数据长度为1k，超过则不允许发送事件。
+// 比，如果差额超过了超时时间(加上事件引擎允许的时钟偏差)则认为事件超时了，事件引擎开启WithDropExpired时事件监听程序不会被执行，反之事件程序会得到执行。
+// 发布时间默认取发布方的本地时间，事件引擎也可以配置为使用配置中心节点的创建时间作为权威的发布时间。
+// 每个事件允许携带一定量的数据，以事件编码(压缩)后的字节数计算(不含Publish写入的ID、TraceParent等信封元数据)，
+// 默认最大为DefaultMaxEventSize字节，事件引擎可以通过WithMaxEventSize配置不同的限制，超过则Publish返回*EventTooLargeError。
+//
+// 存储格式中pub_time和timeout均以毫秒为单位，兼容旧版本以纳秒写入的pub_time。
 type Event struct {
 	Key       string                 `json:"key"`       // 事件的唯一key
-	PubTime   int64                  `json:"pub_time"`  // 事件的发布时间，单位毫秒
-	Timeout   int64                  `json:"timeout"`   // 超时时间，单位毫秒
+	PubTime   time.Time              `json:"-"`         // 事件的发布时间
+	Timeout   time.Duration          `json:"-"`         // 超时时间，不大于0表示永不超时
 	Body      map[string]interface{} `json:"body"`      // 事件的数据
 	Published bool                   `json:"published"` // 是否发布了，防止重复发送
+	Offset    int64                  `json:"-"`         // 事件在其key下的顺序号，由存储节点的序号决定
+	// CorrelationID 请求/应答模式下请求的唯一标识，ReplyTo为应答写入的路径，普通事件均为空
+	CorrelationID string `json:"-"`
+	ReplyTo       string `json:"-"`
+	// ID 事件的唯一标识，Source和Instance为发布方的应用名和实例标识，TraceParent为发布时的W3C traceparent，均由Publish设置
+	ID          string `json:"-"`
+	Source      string `json:"-"`
+	Instance    string `json:"-"`
+	TraceParent string `json:"-"`
+	// Target 重放死信时的投递目标(见DeadLetter.Target)，为空表示投递给该key的所有订阅；DeadLetter为被重放的死信的顺序号，
+	// 目标订阅处理完成后删除该死信
+	Target     string          `json:"-"`
+	DeadLetter int64           `json:"-"`
+	rawBody    json.RawMessage // 接收到的原始body，用于解码类型化的事件数据
+}
+
+// eventJSON 事件的存储格式。
+type eventJSON struct {
+	Key       string                 `json:"key"`
+	PubTime   int64                  `json:"pub_time"` // 单位毫秒
+	Timeout   int64                  `json:"timeout"`  // 单位毫秒
+	Body      map[string]interface{} `json:"body"`
+	Published bool                   `json:"published"`
+	Encoding  Compression            `json:"encoding,omitempty"` // body的压缩算法，压缩后的body存储在data中
+	Data      []byte                 `json:"data,omitempty"`
+	// 请求/应答模式下的请求标识和应答路径
+	CorrelationID string `json:"correlation_id,omitempty"`
+	ReplyTo       string `json:"reply_to,omitempty"`
+	// 追踪信息
+	ID          string `json:"id,omitempty"`
+	Source      string `json:"source,omitempty"`
+	Instance    string `json:"instance,omitempty"`
+	TraceParent string `json:"traceparent,omitempty"`
+	// 重放死信时的投递目标和死信的顺序号
+	Target     string `json:"target,omitempty"`
+	DeadLetter int64  `json:"dead_letter,omitempty"`
+}
+
+// legacyNanoPubTime 大于该值的pub_time是旧版本以纳秒写入的(毫秒时间戳在公元33658年之前都小于该值)。
+const legacyNanoPubTime = int64(1e15)
+
+func (e Event) MarshalJSON() ([]byte, error) {
+	return e.encode(CompressionNone)
+}
+
+// encode 按存储格式编码事件，compression不为空时body被压缩后存储在data字段中。
+func (e Event) encode(compression Compression) ([]byte, error) {
+	v := eventJSON{Key: e.Key, Timeout: e.Timeout.Milliseconds(), Body: e.Body, Published: e.Published, CorrelationID: e.CorrelationID, ReplyTo: e.ReplyTo,
+		ID: e.ID, Source: e.Source, Instance: e.Instance, TraceParent: e.TraceParent, Target: e.Target, DeadLetter: e.DeadLetter}
+	if !e.PubTime.IsZero() {
+		v.PubTime = e.PubTime.UnixMilli()
+	}
+	if compression != CompressionNone {
+		body, err := json.Marshal(e.Body)
+		if err != nil {
+			return nil, err
+		}
+		if v.Data, err = compression.compress(body); err != nil {
+			return nil, err
+		}
+		v.Encoding, v.Body = compression, nil
+	}
+	return json.Marshal(v)
+}
+
+func (e *Event) UnmarshalJSON(b []byte) error {
+	var v eventJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	var raw struct {
+		Body json.RawMessage `json:"body"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	e.Key, e.Body, e.Published, e.rawBody = v.Key, v.Body, v.Published, raw.Body
+	e.CorrelationID, e.ReplyTo = v.CorrelationID, v.ReplyTo
+	e.ID, e.Source, e.Instance, e.TraceParent = v.ID, v.Source, v.Instance, v.TraceParent
+	e.Target, e.DeadLetter = v.Target, v.DeadLetter
+	if v.Encoding != CompressionNone {
+		body, err := v.Encoding.decompress(v.Data)
+		if err != nil {
+			return fmt.Errorf("解压事件[%s]的数据出错:%w", v.Key, err)
+		}
+		e.Body, e.rawBody = nil, body
+		if err = json.Unmarshal(body, &e.Body); err != nil {
+			return err
+		}
+	}
+	e.Timeout = time.Duration(v.Timeout) * time.Millisecond
+	switch {
+	case v.PubTime == 0:
+		e.PubTime = time.Time{}
+	case v.PubTime > legacyNanoPubTime:
+		e.PubTime = time.Unix(0, v.PubTime)
+	default:
+		e.PubTime = time.UnixMilli(v.PubTime)
+	}
+	return nil
 }
 
 // AddData 添加一个key(数据对应的key)-value(数据对应的值)数据到事件对象中。
@@ -30,14 +133,14 @@ func (e *Event) AddData(key string, value interface{}) *Event {
 }
 
 // SetPubTime 设置事件的pubTime(发布时间)，当事件被发布时由系统自动设置。
-func (e *Event) SetPubTime(pubTime int64) *Event {
+func (e *Event) SetPubTime(pubTime time.Time) *Event {
 	e.PubTime = pubTime
 	return e
 }
 
 // SetPublished 标示该事件对象已被发布过。
 func (e *Event) SetPublished() *Event {
-	e.SetPubTime(time.Now().UnixNano())
+	e.SetPubTime(time.Now())
 	e.Published = true
 	return e
 }
@@ -57,14 +160,22 @@ func (e *Event) GetData(key string) interface{} {
 	}
 }
 
-// GePubTime 获取时间的发布时间，单位毫秒。
-func (e Event) GePubTime() int64 {
+// GePubTime 获取事件的发布时间。
+func (e Event) GePubTime() time.Time {
 	return e.PubTime
 }
 
 // IsTimeout 判断当前事件是否超时了，以系统本地时间和发布时间的差额为判断标准。
 func (e Event) IsTimeout() bool {
-	return time.Now().UnixNano()-e.PubTime > e.Timeout
+	return e.IsTimeoutAt(time.Now(), 0)
+}
+
+// IsTimeoutAt 判断事件在now时刻是否超时了，skew为允许的发布方与接收方之间的时钟偏差，超时时间会相应放宽。
+func (e Event) IsTimeoutAt(now time.Time, skew time.Duration) bool {
+	if e.Timeout <= 0 {
+		return false
+	}
+	return now.Sub(e.PubTime) > e.Timeout+skew
 }
 
 // IsPublished 事件是否被发布了，防止重复发送。
@@ -75,109 +186,72 @@ func (e Event) Json() ([]byte, error) {
 	return json.Marshal(e)
 }
 
-// IsOverloaded 判断当前事件数据载体的总字节大小是否超标了，以JSON格式化后的字节数为标准，最大只允许1024字节的数据。
+// IsOverloaded 判断当前事件未压缩编码后的字节数是否超过DefaultMaxEventSize。
+//
+// Deprecated: 不考虑事件引擎通过WithMaxEventSize配置的限制和WithCompression配置的压缩，
+// 请使用事件引擎的CheckSize，或处理Publish返回的*EventTooLargeError。
 func (e *Event) IsOverloaded() bool {
-	if b, err := json.Marshal(e); err != nil {
+	if b, err := e.encode(CompressionNone); err != nil {
 		return false
 	} else {
-		return bytes.Count(b, nil)-1 > 1024
+		return len(b) > DefaultMaxEventSize
 	}
 }
 
-// NewFCEventTimeout 给定事件的key和超时时间来构建一个事件对象，每个事件都有一个发布时间，如果接收时间的系统时间-发布时间>超时时间则认为事件超时了，则接收端系统不会被触发事件处理程序。
-func NewFCEventTimeout(key string, timeout int64) (*Event, error) {
-	if len(key) == 0 {
-		return nil, fmt.Errorf("事件key不能为空")
-	} else {
-		reg, _ := regexp.Compile("[a-z]+([a-z0-9-])*")
-		s := reg.FindStringSubmatch(key)
-		if len(s) == 0 {
-			return nil, fmt.Errorf("事件key不符合规范")
-		} else {
-			return &Event{Key: key, Timeout: timeout, Body: make(map[string]interface{}, 0)}, nil
-		}
-	}
+// EventTooLargeError 事件编码后的大小超过事件引擎的限制时由Publish返回。
+type EventTooLargeError struct {
+	Key   string // 事件key
+	Size  int    // 事件编码(压缩)后的字节数，不含信封元数据
+	Limit int    // 允许的最大字节数
 }
 
-// NewFCEvent 使用事件key构造一个具有默认超时时间的事件对象，默认的超时时间为10s。
-func NewFCEvent(key string) (*Event, error) {
-	return NewFCEventTimeout(key, int64(10000))
+func (e *EventTooLargeError) Error() string {
+	return fmt.Sprintf("事件[%s]的载体数据超标:%d字节，最大允许%d字节", e.Key, e.Size, e.Limit)
 }
 
-// EventListener 跨系统的事件监听器接口定义，适用于两个/多个在线系统之间的实时通知，业务系统只需要实现该接口并注册到服务中后即可实现跨系统的事件监听。
-type EventListener interface {
-	// EventKeys 该监听器要监听的事件key列表(可指定多个)，总是返回非空列表。
-	EventKeys() []string
+const (
+	DefaultEventTimeout = 10 * time.Second // 事件默认的超时时间
+	DefaultMaxEventSize = 1024             // 事件编码后默认允许的最大字节数，不含信封元数据
+)
 
-	// OnEvent 事件监听到的回调处理方法，由业务系统自行处理。
-	OnEvent(event Event)
-}
+// eventKeyPattern 事件key需要满足的规范：以.分隔的一段或多段，每段以小写字母开头，由小写字母、数字和-组成。
+var eventKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9-]*(\.[a-z][a-z0-9-]*)*$`)
 
-func EventEngine(conf backends.StoreConfig) eventEngine {
-	fmt.Println("Loading Aluka Event Engine")
-	store, err := backends.New(conf)
-	if err != nil {
-		panic(err)
+// NewFCEventTimeout 给定事件的key和超时时间来构建一个事件对象，每个事件都有一个发布时间，如果接收时间的系统时间-发布时间>超时时间则认为事件超时了，
+// 事件引擎开启WithDropExpired时接收端系统不会被触发事件处理程序。
+// 旧版本的timeout为int64的毫秒数，直接传入的整数常量(如5000)会被当作纳秒，因此小于1毫秒的timeout按毫秒数处理并记录警告。
+func NewFCEventTimeout(key string, timeout time.Duration) (*Event, error) {
+	if err := checkEventKey(key); err != nil {
+		return nil, err
 	}
-	return eventEngine{store, "/system_events"}
-}
-
-type eventEngine struct {
-	store     backends.StoreClient
-	eventPath string
+	if timeout > 0 && timeout < time.Millisecond {
+		log.Warn().Msgf("事件[%s]的超时时间%d小于1毫秒，按旧版本的毫秒数处理，请改为time.Duration", key, int64(timeout))
+		timeout *= time.Millisecond
+	}
+	return &Event{Key: key, Timeout: timeout, Body: make(map[string]interface{}, 0)}, nil
 }
 
-func (ee eventEngine) Publish(e *Event) error {
-	if e == nil {
-		return fmt.Errorf("发布的事件不能为nil")
-	}
-	if e.IsPublished() {
-		return fmt.Errorf("事件已被发布过")
-	}
-	if e.IsOverloaded() {
-		return fmt.Errorf("事件的载体数据超标")
+// checkEventKey 校验事件key，key会作为事件目录名，规范保证了key不包含路径分隔符，也不会以系统保留的_开头。
+func checkEventKey(key string) error {
+	if len(key) == 0 {
+		return fmt.Errorf("事件key不能为空")
 	}
-	e.SetPublished()
-	// 如果不存在该路径则先创建，然后再设置数据
-	path := ee.eventPath + "/" + e.Key
-	if b, e := e.Json(); e == nil {
-		_, er := ee.store.Add(path, b)
-		return er
-	} else {
-		return e
+	if !eventKeyPattern.MatchString(key) {
+		return fmt.Errorf("事件key[%s]不符合规范", key)
 	}
-}
-func (ee eventEngine) StartEventListener(listener []EventListener) {
-	p := &parser{}
-	p.Init(listener)
-	go WatchProcessor([]string{"/system_events"}, ee.store).Process(p)
+	return nil
 }
 
-type parser struct {
-	listenerMap map[string][]EventListener
+// NewFCEvent 使用事件key构造一个具有默认超时时间的事件对象，默认的超时时间为10s。
+func NewFCEvent(key string) (*Event, error) {
+	return NewFCEventTimeout(key, DefaultEventTimeout)
 }
 
-func (p parser) Init(listener []EventListener) {
-	p.listenerMap = make(map[string][]EventListener, 0)
-	for _, v := range listener {
-		if len(v.EventKeys()) == 0 {
-			continue
-		}
-		for _, e := range v.EventKeys() {
-			list := p.listenerMap[e]
-			list = append(list, v)
-			p.listenerMap[e] = list
-		}
-	}
-}
-func (p parser) Changed(data map[string]string) {
-	fmt.Printf("data:%+v\n", data)
-	for k, v := range data {
-		for _, val := range p.listenerMap[k] {
-			var e Event
-			if err := json.Unmarshal([]byte(v), &e); err == nil {
-				val.OnEvent(e)
-			}
-		}
-	}
+// EventListener 跨系统的事件监听器接口定义，适用于两个/多个在线系统之间的实时通知，业务系统只需要实现该接口并注册到服务中后即可实现跨系统的事件监听。
+type EventListener interface {
+	// EventKeys 该监听器要监听的事件key列表(可指定多个，支持"order-*"、"billing.**"等通配模式)，总是返回非空列表。
+	EventKeys() []string
+
+	// OnEvent 事件监听到的回调处理方法，由业务系统自行处理。同一监听器的OnEvent不会并发调用(即使监听了多个key)。
+	OnEvent(event Event)
 }
@@ -0,0 +1,567 @@
+package configuration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aluka-7/configuration/backends"
+	"github.com/aluka-7/configuration/backends/types"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	EventNamespace  = "/system_events"
+	eventNodePrefix = "evt-"
+	deadLetterDir   = "_dead"   // 死信目录，以_开头的目录为系统保留，不会与事件key冲突
+	groupDir        = "_groups" // 消费组的认领目录
+)
+
+// EventHandler 事件处理函数，ctx在取消订阅后会被取消。返回错误表示处理失败，事件会按订阅的重试策略重试，
+// 重试耗尽后进入死信目录。
+type EventHandler func(ctx context.Context, event Event) error
+
+// RetryableEventListener 可返回处理结果的事件监听器，处理失败时按RetryPolicy重试，重试耗尽后事件进入死信目录。
+type RetryableEventListener interface {
+	// EventKeys 该监听器要监听的事件key列表(可指定多个，支持"order-*"、"billing.**"等通配模式)，总是返回非空列表。
+	EventKeys() []string
+
+	// OnEvent 事件监听到的回调处理方法，返回错误表示处理失败。监听多个key时各key的事件并发调用OnEvent，需自行保证并发安全。
+	OnEvent(ctx context.Context, event Event) error
+
+	// RetryPolicy 处理失败时的重试策略。
+	RetryPolicy() RetryPolicy
+}
+
+// RetryPolicy 事件处理失败时的重试策略，第n次重试前等待Backoff*Multiplier^(n-1)，最长不超过MaxBackoff。
+type RetryPolicy struct {
+	Retries    int           // 失败后的最大重试次数，0表示不重试
+	Backoff    time.Duration // 第一次重试前的等待时间
+	MaxBackoff time.Duration // 最长等待时间，0表示不限制
+	Multiplier float64       // 等待时间的增长倍数，小于1时按1处理
+}
+
+// delay 第n(从1开始)次重试前的等待时间。
+func (p RetryPolicy) delay(n int) time.Duration {
+	d := float64(p.Backoff)
+	for i := 1; i < n && p.Multiplier > 1; i++ {
+		d *= p.Multiplier
+		if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		return p.MaxBackoff
+	}
+	return time.Duration(d)
+}
+
+// GroupEventListener 可由监听器额外实现的接口，返回非空的消费组名时监听器以该消费组成员的身份订阅事件。
+type GroupEventListener interface {
+	ConsumerGroup() string
+}
+
+// SubscribeOption 订阅选项。
+type SubscribeOption func(s *subscription)
+
+// WithRetry 设置订阅的重试策略，默认处理失败时不重试直接进入死信目录。
+func WithRetry(policy RetryPolicy) SubscribeOption {
+	return func(s *subscription) {
+		s.retry = policy
+	}
+}
+
+// WithName 设置订阅的名称，死信重放时以此识别处理失败的(非消费组、无检查点的)订阅，需在实例重启、重新部署后保持不变。
+// 默认为处理函数的名称(包路径.函数名)，同一key有多个由同一函数处理的订阅时应分别指定名称。
+func WithName(name string) SubscribeOption {
+	return func(s *subscription) {
+		s.name = name
+	}
+}
+
+// WithGroup 以消费组成员的身份订阅，同一消费组的所有成员(通常是同一服务的多个实例)中每个事件只会被一个成员处理，
+// 未指定消费组的订阅仍会收到所有事件。成员在处理事件前在/system_events/_groups/<group>/<key>/下创建以事件offset命名的
+// 持久认领节点，创建成功者处理该事件；认领节点是持久的，因此成员重启后也不会重复处理已被认领的事件。
+func WithGroup(group string) SubscribeOption {
+	return func(s *subscription) {
+		s.group = group
+	}
+}
+
+// EventEngine 获取跨系统事件引擎，事件存储在配置中心的/system_events目录下。
+func EventEngine(conf backends.StoreConfig, opts ...EventOption) *eventEngine {
+	fmt.Println("Loading Aluka Event Engine")
+	store, err := backends.New(conf)
+	if err != nil {
+		panic(err)
+	}
+	return newEventEngine(store, opts...)
+}
+
+// MockEventEngine 获取基于内存存储的事件引擎，用于测试。
+func MockEventEngine(t *testing.T, conf backends.StoreConfig, opts ...EventOption) *eventEngine {
+	fmt.Println("Loading Aluka Event Mock Engine")
+	store, err := backends.NewMock(conf)
+	if err != nil {
+		panic(err)
+	}
+	return newEventEngine(store, opts...)
+}
+
+func newEventEngine(store backends.StoreClient, opts ...EventOption) *eventEngine {
+	ee := &eventEngine{store: store, eventPath: EventNamespace, maxSize: DefaultMaxEventSize, subs: make(map[*subscription]struct{})}
+	ee.instance, _ = os.Hostname()
+	for _, opt := range opts {
+		opt(ee)
+	}
+	return ee
+}
+
+// EventOption 事件引擎选项。
+type EventOption func(ee *eventEngine)
+
+// WithClockSkew 设置允许的发布方与接收方之间的时钟偏差，判断事件是否超时时超时时间会放宽skew，默认为0。
+func WithClockSkew(skew time.Duration) EventOption {
+	return func(ee *eventEngine) {
+		ee.clockSkew = skew
+	}
+}
+
+// WithMaxEventSize 设置事件编码(压缩)后允许的最大字节数，ID、TraceParent等信封元数据不计入，默认为DefaultMaxEventSize，不大于0表示不限制。
+func WithMaxEventSize(size int) EventOption {
+	return func(ee *eventEngine) {
+		ee.maxSize = size
+	}
+}
+
+// WithCompression 设置发布事件时body使用的压缩算法，压缩后的事件带有encoding标记，接收方自动解压。
+func WithCompression(compression Compression) EventOption {
+	return func(ee *eventEngine) {
+		ee.compress = compression
+	}
+}
+
+// WithSource 设置发布方的应用名，发布的事件会带上该应用名，便于追查事件来源。
+func WithSource(app string) EventOption {
+	return func(ee *eventEngine) {
+		ee.source = app
+	}
+}
+
+// WithInstance 设置当前实例的标识，默认为主机名。实例标识会写入发布的事件、消费组的认领节点和请求的应答中。
+func WithInstance(id string) EventOption {
+	return func(ee *eventEngine) {
+		ee.instance = id
+	}
+}
+
+// WithDropExpired 订阅时丢弃超时的事件(以接收时的本地时间判断，见WithClockSkew和WithServerTime)，
+// 默认与旧版本一致，超时的事件同样投递，由处理程序通过Event.IsTimeoutAt自行判断。重放的历史事件不受影响。
+func WithDropExpired() EventOption {
+	return func(ee *eventEngine) {
+		ee.dropExpired = true
+	}
+}
+
+// WithServerTime 使用配置中心事件节点的创建时间(ctime)作为事件的发布时间，不再信任发布方的本地时钟。
+func WithServerTime() EventOption {
+	return func(ee *eventEngine) {
+		ee.serverTime = true
+	}
+}
+
+type eventEngine struct {
+	store       backends.StoreClient
+	eventPath   string
+	clockSkew   time.Duration // 允许的时钟偏差
+	serverTime  bool          // 是否以节点创建时间作为发布时间
+	dropExpired bool          // 是否丢弃超时的事件
+	retention   RetentionPolicy
+	codec       PayloadCodec // Publish[T]使用的编解码器，为空时使用JSONCodec
+	maxSize     int          // 事件编码后允许的最大字节数，不大于0表示不限制
+	compress    Compression  // 发布事件时body使用的压缩算法
+	source      string       // 发布方的应用名
+	instance    string       // 当前实例的标识
+	janitor     janitor
+	mu          sync.Mutex
+	subs        map[*subscription]struct{}
+}
+
+// keyPath 事件key对应的存储目录。
+func (ee *eventEngine) keyPath(key string) string {
+	return ee.eventPath + "/" + key
+}
+
+// Publish 发布事件，每个事件以持久顺序节点的方式存储在事件key对应的目录下(/system_events/<key>/evt-<offset>)，
+// 同一个key的多个事件不会相互覆盖，节点序号即事件的offset，单调递增。发布成功后事件的Offset会被设置。
+func (ee *eventEngine) Publish(e *Event) error {
+	return ee.PublishContext(context.Background(), e)
+}
+
+// PublishContext 发布事件，并为事件设置唯一ID、发布方的应用名和实例标识，以及ctx携带的W3C traceparent，
+// ctx未携带traceparent时开启一个新的trace。接收方处理函数的ctx会携带该traceparent。
+func (ee *eventEngine) PublishContext(ctx context.Context, e *Event) error {
+	if e == nil {
+		return fmt.Errorf("发布的事件不能为nil")
+	}
+	if err := checkEventKey(e.Key); err != nil {
+		return err
+	}
+	if e.IsPublished() {
+		return fmt.Errorf("事件已被发布过")
+	}
+	prev := *e
+	err := ee.stamp(ctx, e)
+	var b []byte
+	if err == nil {
+		b, err = ee.encode(e)
+	}
+	if err != nil {
+		*e = prev
+		return err
+	}
+	// 如果不存在该路径则先创建，然后再设置数据
+	dir := ee.keyPath(e.Key)
+	if err = ensurePath(ee.store, dir); err != nil {
+		return err
+	}
+	node, err := ee.store.Add(dir+"/"+eventNodePrefix, b, backends.FlagSequence)
+	if err != nil {
+		return err
+	}
+	e.Offset, err = types.ParseSeq(node)
+	return err
+}
+
+// encode 按引擎配置的压缩方式编码事件，事件(不含信封元数据，见payloadSize)编码后超过WithMaxEventSize的限制时返回*EventTooLargeError。
+func (ee *eventEngine) encode(e *Event) ([]byte, error) {
+	b, err := e.encode(ee.compress)
+	if err != nil || ee.maxSize <= 0 || len(b) <= ee.maxSize {
+		return b, err
+	}
+	size, err := ee.payloadSize(e)
+	if err == nil && size > ee.maxSize {
+		err = &EventTooLargeError{Key: e.Key, Size: size, Limit: ee.maxSize}
+	}
+	return b, err
+}
+
+// payloadSize 事件去掉Publish/Request/ReplayDeadLetter写入的信封元数据(ID、Source、Instance、TraceParent、
+// CorrelationID、ReplyTo、Target、DeadLetter)后按引擎配置的压缩方式编码的字节数，元数据的大小与调用方的数据无关，不计入大小限制。
+func (ee *eventEngine) payloadSize(e *Event) (int, error) {
+	c := *e
+	c.ID, c.Source, c.Instance, c.TraceParent = "", "", "", ""
+	c.CorrelationID, c.ReplyTo, c.Target, c.DeadLetter = "", "", "", 0
+	b, err := c.encode(ee.compress)
+	return len(b), err
+}
+
+// CheckSize 按本引擎的压缩方式和WithMaxEventSize的限制检查事件的大小，超过时返回*EventTooLargeError，
+// 与Publish的检查一致(信封元数据不计入)，不会修改e。
+func (ee *eventEngine) CheckSize(e *Event) error {
+	c := *e
+	if err := ee.stamp(context.Background(), &c); err != nil {
+		return err
+	}
+	_, err := ee.encode(&c)
+	return err
+}
+
+// stamp 标记事件已发布并设置追踪信息。
+func (ee *eventEngine) stamp(ctx context.Context, e *Event) (err error) {
+	e.SetPublished()
+	if len(e.ID) == 0 {
+		if e.ID, err = randomHex(16); err != nil {
+			return err
+		}
+	}
+	e.Source, e.Instance = ee.source, ee.instance
+	if e.TraceParent = TraceParentFromContext(ctx); len(e.TraceParent) == 0 {
+		e.TraceParent, err = newTraceParent()
+	}
+	return err
+}
+
+// Subscribe 订阅指定key的事件，只投递订阅之后发布的事件，同一订阅内的事件按offset顺序串行投递给handler。
+// key可以是通配模式：*匹配key中不含.的任意字符，**匹配任意字符，如"order-*"、"billing.**"，
+// 通配订阅对每个匹配的key建立独立的订阅，订阅之后新出现的key从其第一个事件开始投递。
+// 返回的函数用于取消订阅，可重复调用。
+func (ee *eventEngine) Subscribe(key string, handler EventHandler, opts ...SubscribeOption) (func(), error) {
+	pattern := isKeyPattern(key)
+	if pattern {
+		if err := checkKeyPattern(key); err != nil {
+			return nil, err
+		}
+	} else if err := checkEventKey(key); err != nil {
+		return nil, err
+	}
+	s, err := ee.subscription(context.Background(), key, handler, opts)
+	if err != nil {
+		return nil, err
+	}
+	ee.mu.Lock()
+	ee.subs[s] = struct{}{}
+	ee.mu.Unlock()
+	if pattern {
+		// 已存在的key从最新的offset开始，之后新出现的key从第一个事件开始
+		match := keyMatcher(key)
+		known := ee.existingKeys(match)
+		for k := range known {
+			sub, _ := ee.subscription(s.ctx, k, handler, opts)
+			sub.seek(false)
+			go sub.run()
+		}
+		go ee.watchKeys(s.ctx, match, known, func(k string) {
+			sub, _ := ee.subscription(s.ctx, k, handler, opts)
+			sub.seek(true)
+			go sub.run()
+		})
+	} else {
+		s.seek(false)
+		go s.run()
+	}
+	return func() {
+		ee.mu.Lock()
+		delete(ee.subs, s)
+		ee.mu.Unlock()
+		s.cancel()
+	}, nil
+}
+
+// subscription 创建一个随parent取消的订阅。
+func (ee *eventEngine) subscription(parent context.Context, key string, handler EventHandler, opts []SubscribeOption) (*subscription, error) {
+	ctx, cancel := context.WithCancel(parent)
+	s := &subscription{engine: ee, store: ee.store, key: key, dir: ee.keyPath(key), handler: handler, name: handlerName(handler), ctx: ctx, cancel: cancel}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if strings.Contains(s.group, "/") {
+		cancel()
+		return nil, fmt.Errorf("消费组名[%s]不符合规范", s.group)
+	}
+	if strings.Contains(s.checkpoint, "/") {
+		cancel()
+		return nil, fmt.Errorf("检查点名称[%s]不符合规范", s.checkpoint)
+	}
+	return s, nil
+}
+
+// StartEventListener 启动事件监听，监听器的每个事件key都会建立一个订阅，
+// 各订阅在各自的协程中投递事件，同一监听器的OnEvent按顺序调用，不会并发执行。
+func (ee *eventEngine) StartEventListener(listener []EventListener) {
+	for _, l := range listener {
+		l := l
+		mu := new(sync.Mutex)
+		for _, k := range l.EventKeys() {
+			if _, err := ee.Subscribe(k, func(ctx context.Context, e Event) error {
+				mu.Lock()
+				defer mu.Unlock()
+				l.OnEvent(e)
+				return nil
+			}, WithName(fmt.Sprintf("%T", l)), listenerGroup(l)); err != nil {
+				log.Err(err).Msgf("监听事件[%s]出错", k)
+			}
+		}
+	}
+}
+
+// StartRetryableEventListener 启动可重试的事件监听，监听器的每个事件key都会按其重试策略建立一个订阅。
+func (ee *eventEngine) StartRetryableEventListener(listener []RetryableEventListener) {
+	for _, l := range listener {
+		for _, k := range l.EventKeys() {
+			if _, err := ee.Subscribe(k, l.OnEvent, WithName(fmt.Sprintf("%T", l)), WithRetry(l.RetryPolicy()), listenerGroup(l)); err != nil {
+				log.Err(err).Msgf("监听事件[%s]出错", k)
+			}
+		}
+	}
+}
+
+// listenerGroup 监听器实现了GroupEventListener时返回对应的消费组选项。
+func listenerGroup(l interface{}) SubscribeOption {
+	if g, ok := l.(GroupEventListener); ok {
+		return WithGroup(g.ConsumerGroup())
+	}
+	return WithGroup("")
+}
+
+// Close 取消所有订阅。
+func (ee *eventEngine) Close() {
+	ee.mu.Lock()
+	defer ee.mu.Unlock()
+	for s := range ee.subs {
+		s.cancel()
+		delete(ee.subs, s)
+	}
+}
+
+// subscription 顺序消费一个事件key目录下的事件节点并记录最后处理的offset，
+// 监听每次触发时投递所有offset更大的事件，因此两次触发之间发布的多个事件也不会丢失。
+type subscription struct {
+	engine  *eventEngine
+	store   backends.StoreClient
+	key     string
+	dir     string
+	offset  int64 // 最后处理的事件offset
+	backlog int64 // 重放的事件中最大的offset，不大于它的事件不做超时判断
+	handler EventHandler
+	retry   RetryPolicy
+	group   string // 消费组名，为空表示广播订阅
+	name    string // 订阅的名称，见WithName
+	claimed bool   // 消费组的认领目录是否已创建
+	start   startPosition
+	// checkpoint 检查点名称，不为空时每处理一个事件都会将offset保存到/system_events/_offsets/<checkpoint>/<key>
+	checkpoint string
+	ctx        context.Context
+	cancel     context.CancelFunc
+}
+
+func (s *subscription) run() {
+	for s.ctx.Err() == nil {
+		children, ch, err := s.store.ChildrenW(s.dir)
+		if err == backends.ErrNoNode {
+			// 事件key目录尚未创建，等待第一个事件发布
+			var exists bool
+			if exists, ch, err = s.store.ExistsW(s.dir); err == nil && exists {
+				continue
+			}
+		}
+		if err != nil {
+			log.Err(err).Msgf("监听事件目录[%s]出错", s.dir)
+			// 防止后端错误占用所有资源.
+			s.sleep(time.Second * 2)
+			continue
+		}
+		s.consume(sortSeq(children))
+		select {
+		case <-ch:
+		case <-s.ctx.Done():
+		}
+	}
+}
+
+func (s *subscription) consume(nodes []seqNode) {
+	for _, n := range nodes {
+		if n.seq <= s.offset {
+			continue
+		}
+		if s.ctx.Err() != nil {
+			return
+		}
+		path := s.dir + "/" + n.name
+		b, stat, err := s.store.Get(path)
+		if err != nil && err != backends.ErrNoNode {
+			log.Err(err).Msgf("读取事件[%s]出错", path)
+			return
+		}
+		if err == backends.ErrNoNode {
+			// 事件已被清理
+			s.offset = n.seq
+			continue
+		}
+		var e Event
+		if err = json.Unmarshal(b, &e); err != nil {
+			log.Err(err).Msgf("解析事件[%s]出错", path)
+		} else {
+			e.Offset = n.seq
+			if s.engine.serverTime {
+				e.PubTime = stat.Ctime
+			}
+			if len(e.Target) > 0 && e.Target != s.target() {
+				// 重放给其他订阅的死信
+			} else if s.engine.dropExpired && n.seq > s.backlog && e.IsTimeoutAt(time.Now(), s.engine.clockSkew) {
+				log.Info().Msgf("事件[%s]已超时，发布时间:%s", path, e.PubTime)
+			} else if s.claim(e) {
+				s.dispatch(e)
+			}
+		}
+		s.offset = n.seq
+		s.saveCheckpoint()
+	}
+}
+
+// target 订阅的标识，用于死信只重放给处理失败的订阅：消费组订阅为group:<消费组名>，带检查点的订阅为checkpoint:<检查点名称>，
+// 其他订阅为name:<订阅名称>。
+func (s *subscription) target() string {
+	switch {
+	case len(s.group) > 0:
+		return "group:" + s.group
+	case len(s.checkpoint) > 0:
+		return "checkpoint:" + s.checkpoint
+	default:
+		return "name:" + s.name
+	}
+}
+
+// handlerName 处理函数的名称(包路径.函数名)。
+func handlerName(fn interface{}) string {
+	if f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()); f != nil {
+		return f.Name()
+	}
+	return ""
+}
+
+// claim 消费组订阅在处理事件前认领事件，返回是否由当前成员处理，广播订阅总是返回true。
+// 认领出错时放弃处理，避免同一事件在组内被重复处理。
+func (s *subscription) claim(e Event) bool {
+	if len(s.group) == 0 {
+		return true
+	}
+	dir := s.engine.eventPath + "/" + groupDir + "/" + s.group + "/" + s.key
+	if !s.claimed {
+		if err := ensurePath(s.store, dir); err != nil {
+			log.Err(err).Msgf("创建消费组[%s]的认领目录出错", s.group)
+			return false
+		}
+		s.claimed = true
+	}
+	_, err := s.store.Add(fmt.Sprintf("%s/%010d", dir, e.Offset), []byte(s.engine.instance), 0)
+	if err != nil && err != backends.ErrNodeExists {
+		log.Err(err).Msgf("消费组[%s]认领事件[%s/%d]出错", s.group, s.key, e.Offset)
+	}
+	return err == nil
+}
+
+// dispatch 投递事件，失败时按重试策略重试，重试耗尽后写入死信目录。取消订阅时放弃剩余的重试。
+// 处理函数的ctx携带事件发布时的traceparent。
+func (s *subscription) dispatch(e Event) {
+	ctx := s.ctx
+	if len(e.TraceParent) > 0 {
+		ctx = ContextWithTraceParent(ctx, e.TraceParent)
+	}
+	err := s.handler(ctx, e)
+	attempts := 1
+	for ; err != nil && attempts <= s.retry.Retries; attempts++ {
+		s.sleep(s.retry.delay(attempts))
+		if s.ctx.Err() != nil {
+			return
+		}
+		err = s.handler(ctx, e)
+	}
+	if err != nil {
+		log.Err(err).Msgf("处理事件[%s/%d]失败%d次", s.key, e.Offset, attempts)
+		if er := s.engine.deadLetter(e, s.target(), err, attempts); er != nil {
+			log.Err(er).Msgf("事件[%s/%d]写入死信目录出错", s.key, e.Offset)
+			return
+		}
+	}
+	if e.DeadLetter > 0 {
+		// 重放的死信已处理完成(再次失败时已写入新的死信)
+		if er := s.store.Delete(s.engine.deadLetterNode(s.key, e.DeadLetter)); er != nil && er != backends.ErrNoNode {
+			log.Err(er).Msgf("删除已重放的死信[%s/%d]出错", s.key, e.DeadLetter)
+		}
+	}
+}
+
+func (s *subscription) sleep(d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-s.ctx.Done():
+	}
+}
@@ -0,0 +1,112 @@
+package configuration
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aluka-7/configuration/backends"
+	"github.com/rs/zerolog/log"
+)
+
+// isKeyPattern 判断订阅的key是否为通配模式。
+func isKeyPattern(key string) bool {
+	return strings.Contains(key, "*")
+}
+
+// checkKeyPattern 校验通配模式，将通配符替换为普通字符后需满足事件key的规范，且**只能作为完整的一段出现。
+func checkKeyPattern(pattern string) error {
+	for _, seg := range strings.Split(pattern, ".") {
+		if strings.Contains(seg, "**") && seg != "**" {
+			return fmt.Errorf("事件key模式[%s]不符合规范，**只能作为完整的一段", pattern)
+		}
+	}
+	if err := checkEventKey(strings.ReplaceAll(pattern, "*", "x")); err != nil {
+		return fmt.Errorf("事件key模式[%s]不符合规范", pattern)
+	}
+	return nil
+}
+
+// keyMatcher 将通配模式编译为匹配事件key的函数，*匹配不含.的任意字符，**匹配任意字符(可以为空)。
+func keyMatcher(pattern string) func(key string) bool {
+	var b strings.Builder
+	b.WriteString("^")
+	segs := strings.Split(pattern, ".")
+	skipDot := false
+	for i, seg := range segs {
+		switch {
+		case seg == "**" && i == 0 && len(segs) > 1:
+			b.WriteString(`(.*\.)?`)
+			skipDot = true
+			continue
+		case seg == "**" && i > 0:
+			b.WriteString(`(\..*)?`)
+			continue
+		case i > 0 && !skipDot:
+			b.WriteString(`\.`)
+		}
+		skipDot = false
+		if seg == "**" {
+			b.WriteString(".*")
+			continue
+		}
+		parts := strings.Split(seg, "*")
+		for j, p := range parts {
+			if j > 0 {
+				b.WriteString(`[^.]*`)
+			}
+			b.WriteString(regexp.QuoteMeta(p))
+		}
+	}
+	b.WriteString("$")
+	re := regexp.MustCompile(b.String())
+	return re.MatchString
+}
+
+// existingKeys 当前事件目录下匹配的key。
+func (ee *eventEngine) existingKeys(match func(key string) bool) map[string]bool {
+	known := make(map[string]bool)
+	if children, err := ee.store.Children(ee.eventPath); err == nil {
+		for _, k := range children {
+			if !strings.HasPrefix(k, "_") && match(k) {
+				known[k] = true
+			}
+		}
+	}
+	return known
+}
+
+// watchKeys 监听事件目录下新出现的key，每个不在known中且匹配的key调用一次found。
+func (ee *eventEngine) watchKeys(ctx context.Context, match func(key string) bool, known map[string]bool, found func(key string)) {
+	for ctx.Err() == nil {
+		children, ch, err := ee.store.ChildrenW(ee.eventPath)
+		if err == backends.ErrNoNode {
+			var exists bool
+			if exists, ch, err = ee.store.ExistsW(ee.eventPath); err == nil && exists {
+				continue
+			}
+		}
+		if err != nil {
+			log.Err(err).Msgf("监听事件目录[%s]出错", ee.eventPath)
+			// 防止后端错误占用所有资源.
+			select {
+			case <-time.After(time.Second * 2):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		for _, k := range children {
+			if known[k] || strings.HasPrefix(k, "_") || !match(k) {
+				continue
+			}
+			known[k] = true
+			found(k)
+		}
+		select {
+		case <-ch:
+		case <-ctx.Done():
+		}
+	}
+}
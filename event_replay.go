@@ -0,0 +1,133 @@
+package configuration
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aluka-7/configuration/backends"
+	"github.com/rs/zerolog/log"
+)
+
+const offsetDir = "_offsets" // 订阅检查点目录
+
+type startMode int
+
+const (
+	startLatest startMode = iota
+	startEarliest
+	startOffset
+	startTime
+)
+
+// startPosition 订阅开始消费的位置。
+type startPosition struct {
+	mode   startMode
+	offset int64
+	time   time.Time
+}
+
+// FromEarliest 从保留的最早事件开始投递，先重放已存储的事件再切换为实时投递，重放的事件不受其超时时间限制。
+func FromEarliest() SubscribeOption {
+	return func(s *subscription) {
+		s.start = startPosition{mode: startEarliest}
+	}
+}
+
+// FromOffset 从offset之后的事件开始投递，offset通常是上次处理的最后一个事件的offset。
+func FromOffset(offset int64) SubscribeOption {
+	return func(s *subscription) {
+		s.start = startPosition{mode: startOffset, offset: offset}
+	}
+}
+
+// FromTime 从节点创建时间不早于t的第一个事件开始投递。
+func FromTime(t time.Time) SubscribeOption {
+	return func(s *subscription) {
+		s.start = startPosition{mode: startTime, time: t}
+	}
+}
+
+// WithCheckpoint 将处理进度以name为检查点名称保存到配置中心，再次以同一名称订阅时从保存的offset之后继续投递，
+// 检查点存在时优先于FromEarliest/FromOffset/FromTime。
+func WithCheckpoint(name string) SubscribeOption {
+	return func(s *subscription) {
+		s.checkpoint = name
+	}
+}
+
+// seek 确定订阅开始消费的offset，isNew表示该key在订阅之后才出现(通配订阅)，此时从其第一个事件开始。
+// 从检查点或FromEarliest/FromOffset/FromTime重放时，记录订阅时已存储的最大offset，重放的这些事件不做超时判断。
+func (s *subscription) seek(isNew bool) {
+	offset, resumed := s.loadCheckpoint()
+	if isNew && !resumed {
+		return
+	}
+	var nodes []seqNode
+	if children, err := s.store.Children(s.dir); err == nil {
+		nodes = sortSeq(children)
+	}
+	var latest int64
+	if len(nodes) > 0 {
+		latest = nodes[len(nodes)-1].seq
+	}
+	if resumed {
+		s.offset, s.backlog = offset, latest
+		return
+	}
+	switch s.start.mode {
+	case startEarliest:
+		s.offset = 0
+	case startOffset:
+		s.offset = s.start.offset
+	case startTime:
+		// 顺序节点的创建时间随序号递增，二分查找第一个不早于指定时间的事件，定位到其之前；
+		// 读取失败(如已被清理)的节点视为早于指定时间
+		i := sort.Search(len(nodes), func(i int) bool {
+			_, stat, err := s.store.Get(s.dir + "/" + nodes[i].name)
+			return err == nil && !stat.Ctime.Before(s.start.time)
+		})
+		if s.offset = latest; i < len(nodes) {
+			s.offset = nodes[i].seq - 1
+		}
+	default:
+		s.offset = latest
+		return
+	}
+	s.backlog = latest
+}
+
+func (s *subscription) checkpointPath() string {
+	return s.engine.eventPath + "/" + offsetDir + "/" + s.checkpoint + "/" + s.key
+}
+
+func (s *subscription) loadCheckpoint() (int64, bool) {
+	if len(s.checkpoint) == 0 {
+		return 0, false
+	}
+	b, _, err := s.store.Get(s.checkpointPath())
+	if err != nil {
+		if err != backends.ErrNoNode {
+			log.Err(err).Msgf("读取检查点[%s]出错", s.checkpointPath())
+		}
+		return 0, false
+	}
+	offset, err := strconv.ParseInt(string(b), 10, 64)
+	return offset, err == nil
+}
+
+func (s *subscription) saveCheckpoint() {
+	if len(s.checkpoint) == 0 {
+		return
+	}
+	path, value := s.checkpointPath(), []byte(strconv.FormatInt(s.offset, 10))
+	err := s.store.Modify(path, value)
+	if err == backends.ErrNoNode {
+		if err = ensurePath(s.store, path[:len(path)-len(s.key)-1]); err == nil {
+			_, err = s.store.Add(path, value, 0)
+		}
+	}
+	if err != nil {
+		log.Err(err).Msgf("保存检查点[%s]出错", path)
+	}
+}
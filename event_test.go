@@ -0,0 +1,803 @@
+package configuration
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aluka-7/configuration/backends"
+	"github.com/aluka-7/configuration/backends/mock"
+)
+
+type recorder struct {
+	keys   []string
+	events chan Event
+}
+
+func newRecorder(keys ...string) *recorder {
+	return &recorder{keys: keys, events: make(chan Event, 10)}
+}
+
+func (r *recorder) EventKeys() []string {
+	return r.keys
+}
+
+func (r *recorder) OnEvent(e Event) {
+	r.events <- e
+}
+
+func (r *recorder) handle(ctx context.Context, e Event) error {
+	r.events <- e
+	return nil
+}
+
+func (r *recorder) next(t *testing.T) Event {
+	t.Helper()
+	select {
+	case e := <-r.events:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("未收到事件")
+		return Event{}
+	}
+}
+
+func (r *recorder) none(t *testing.T) {
+	t.Helper()
+	select {
+	case e := <-r.events:
+		t.Fatalf("不应收到事件:%+v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func publish(t *testing.T, ee *eventEngine, key string, data map[string]interface{}) *Event {
+	t.Helper()
+	e, err := NewFCEvent(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range data {
+		e.AddData(k, v)
+	}
+	if err = ee.Publish(e); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestPublishSequential(t *testing.T) {
+	ee := MockEventEngine(t, backends.StoreConfig{})
+	r := newRecorder("order-created")
+	ee.StartEventListener([]EventListener{r})
+	for i := 0; i < 3; i++ {
+		publish(t, ee, "order-created", map[string]interface{}{"n": i})
+	}
+	for i := 0; i < 3; i++ {
+		e := r.next(t)
+		if n := e.GetData("n"); n != float64(i) {
+			t.Error("事件顺序不匹配\n", "预期:", i, "|", "实际:", n)
+		}
+		if e.Offset != int64(i+1) {
+			t.Error("事件offset不匹配\n", "预期:", i+1, "|", "实际:", e.Offset)
+		}
+	}
+}
+
+func TestStartEventListener(t *testing.T) {
+	ee := MockEventEngine(t, backends.StoreConfig{})
+	r1, r2 := newRecorder("user-created", "user-deleted"), newRecorder("user-deleted")
+	ee.StartEventListener([]EventListener{r1, r2})
+	publish(t, ee, "user-created", nil)
+	if e := r1.next(t); e.Key != "user-created" {
+		t.Error("事件key不匹配\n", "预期:", "user-created", "|", "实际:", e.Key)
+	}
+	r2.none(t)
+	publish(t, ee, "user-deleted", nil)
+	r1.next(t)
+	r2.next(t)
+}
+
+func TestSubscribe(t *testing.T) {
+	ee := MockEventEngine(t, backends.StoreConfig{})
+	publish(t, ee, "cache-flush", nil)
+	r := newRecorder()
+	unsubscribe, err := ee.Subscribe("cache-flush", r.handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.none(t)
+	publish(t, ee, "cache-flush", map[string]interface{}{"region": "a"})
+	if e := r.next(t); e.GetData("region") != "a" {
+		t.Error("只应收到订阅之后发布的事件:", e)
+	}
+	unsubscribe()
+	unsubscribe()
+	publish(t, ee, "cache-flush", nil)
+	r.none(t)
+	if _, err = ee.Subscribe("", r.handle); err == nil {
+		t.Error("订阅空key应返回错误")
+	}
+}
+
+// serialListener 记录OnEvent的最大并发数。
+type serialListener struct {
+	running, max atomic.Int32
+	events       chan Event
+}
+
+func (l *serialListener) EventKeys() []string {
+	return []string{"stock-in", "stock-out"}
+}
+
+func (l *serialListener) OnEvent(e Event) {
+	if n := l.running.Add(1); n > l.max.Load() {
+		l.max.Store(n)
+	}
+	time.Sleep(5 * time.Millisecond)
+	l.running.Add(-1)
+	l.events <- e
+}
+
+func TestEventListenerSerial(t *testing.T) {
+	ee := MockEventEngine(t, backends.StoreConfig{})
+	l := &serialListener{events: make(chan Event, 20)}
+	ee.StartEventListener([]EventListener{l})
+	for i := 0; i < 5; i++ {
+		publish(t, ee, "stock-in", nil)
+		publish(t, ee, "stock-out", nil)
+	}
+	for i := 0; i < 10; i++ {
+		select {
+		case <-l.events:
+		case <-time.After(time.Second):
+			t.Fatal("未收到事件,已收到:", i)
+		}
+	}
+	if max := l.max.Load(); max != 1 {
+		t.Error("同一监听器的OnEvent不应并发调用,最大并发数:", max)
+	}
+}
+
+func TestRetryAndDeadLetter(t *testing.T) {
+	ee := MockEventEngine(t, backends.StoreConfig{})
+	attempts := make(chan int, 10)
+	fail := true
+	n := 0
+	_, err := ee.Subscribe("report-build", func(ctx context.Context, e Event) error {
+		n++
+		attempts <- n
+		if fail {
+			return fmt.Errorf("第%d次处理失败", n)
+		}
+		return nil
+	}, WithRetry(RetryPolicy{Retries: 2, Backoff: time.Millisecond, Multiplier: 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish(t, ee, "report-build", map[string]interface{}{"id": "r1"})
+	for i := 1; i <= 3; i++ {
+		select {
+		case <-attempts:
+		case <-time.After(time.Second):
+			t.Fatal("重试次数不足,已处理:", i-1)
+		}
+	}
+	var letters []DeadLetter
+	for i := 0; i < 100 && len(letters) == 0; i++ {
+		time.Sleep(5 * time.Millisecond)
+		letters, _ = ee.DeadLetters("report-build")
+	}
+	if len(letters) != 1 || letters[0].Attempts != 3 || letters[0].Event.GetData("id") != "r1" {
+		t.Fatalf("死信不匹配:%+v", letters)
+	}
+	fail = false
+	if err = ee.ReplayDeadLetter("report-build", letters[0].Offset); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-attempts:
+	case <-time.After(time.Second):
+		t.Fatal("重放的死信未被投递")
+	}
+	for i := 0; i < 100 && len(letters) != 0; i++ {
+		time.Sleep(5 * time.Millisecond)
+		letters, _ = ee.DeadLetters("report-build")
+	}
+	if len(letters) != 0 {
+		t.Error("重放的死信处理完成后应被删除:", letters)
+	}
+}
+
+func TestReplayDeadLetterToTarget(t *testing.T) {
+	store, _ := backends.NewMock(backends.StoreConfig{})
+	ee := newEventEngine(store)
+	audit := newRecorder()
+	if _, err := ee.Subscribe("order-paid", audit.handle, WithName("audit")); err != nil {
+		t.Fatal(err)
+	}
+	billing := newRecorder()
+	var fail atomic.Bool
+	fail.Store(true)
+	billingHandler := func(ctx context.Context, e Event) error {
+		if fail.Load() {
+			return errors.New("扣款失败")
+		}
+		return billing.handle(ctx, e)
+	}
+	cancel, err := ee.Subscribe("order-paid", billingHandler, WithName("billing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish(t, ee, "order-paid", map[string]interface{}{"id": "o1"})
+	audit.next(t)
+	var letters []DeadLetter
+	for i := 0; i < 100 && len(letters) == 0; i++ {
+		time.Sleep(5 * time.Millisecond)
+		letters, _ = ee.DeadLetters("order-paid")
+	}
+	if len(letters) != 1 || letters[0].Target != "name:billing" {
+		t.Fatalf("死信不匹配:%+v", letters)
+	}
+
+	// 没有匹配的订阅时死信保留
+	cancel()
+	if err = ee.ReplayDeadLetter("order-paid", letters[0].Offset); err != nil {
+		t.Fatal(err)
+	}
+	audit.none(t)
+	if letters, _ = ee.DeadLetters("order-paid"); len(letters) != 1 {
+		t.Fatal("没有匹配的订阅时死信不应被删除:", letters)
+	}
+
+	// 以相同名称重新订阅(如实例重新部署)后重放，只投递给该订阅
+	fail.Store(false)
+	ee = newEventEngine(store.(*mock.Client).NewSession())
+	if _, err = ee.Subscribe("order-paid", billingHandler, WithName("billing")); err != nil {
+		t.Fatal(err)
+	}
+	if err = ee.ReplayDeadLetter("order-paid", letters[0].Offset); err != nil {
+		t.Fatal(err)
+	}
+	if e := billing.next(t); e.GetData("id") != "o1" {
+		t.Error("重放的死信不匹配:", e)
+	}
+	audit.none(t)
+	for i := 0; i < 100 && len(letters) != 0; i++ {
+		time.Sleep(5 * time.Millisecond)
+		letters, _ = ee.DeadLetters("order-paid")
+	}
+	if len(letters) != 0 {
+		t.Error("重放的死信处理完成后应被删除:", letters)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond, Multiplier: 2}
+	for n, expected := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond} {
+		if actual := p.delay(n + 1); actual != expected {
+			t.Error("重试等待时间不匹配\n", "预期:", expected, "|", "实际:", actual)
+		}
+	}
+}
+
+func TestConsumerGroup(t *testing.T) {
+	store, _ := backends.NewMock(backends.StoreConfig{})
+	members := []*eventEngine{newEventEngine(store), newEventEngine(store.(*mock.Client).NewSession())}
+	handled := make(chan string, 20)
+	for i, ee := range members {
+		name := fmt.Sprint("member", i)
+		if _, err := ee.Subscribe("cache-rebuild", func(ctx context.Context, e Event) error {
+			handled <- name
+			return nil
+		}, WithGroup("svc")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	broadcast := newRecorder()
+	members[0].Subscribe("cache-rebuild", broadcast.handle)
+	for i := 0; i < 5; i++ {
+		publish(t, members[1], "cache-rebuild", nil)
+	}
+	for i := 0; i < 5; i++ {
+		broadcast.next(t)
+		select {
+		case <-handled:
+		case <-time.After(time.Second):
+			t.Fatal("消费组未处理全部事件,已处理:", i)
+		}
+	}
+	select {
+	case m := <-handled:
+		t.Error("同一事件在消费组内被重复处理:", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestKeyMatcher(t *testing.T) {
+	cases := []struct {
+		pattern string
+		key     string
+		match   bool
+	}{
+		{"order-*", "order-created", true},
+		{"order-*", "order-", true},
+		{"order-*", "order.created", false},
+		{"order-*", "user-created", false},
+		{"billing.**", "billing", true},
+		{"billing.**", "billing.invoice.paid", true},
+		{"billing.**", "billingx", false},
+		{"billing.*", "billing.invoice", true},
+		{"billing.*", "billing.invoice.paid", false},
+		{"**.paid", "billing.invoice.paid", true},
+		{"**.paid", "paid", true},
+		{"a.**.z", "a.b.c.z", true},
+		{"a.**.z", "a.z", true},
+	}
+	for _, c := range cases {
+		if actual := keyMatcher(c.pattern)(c.key); actual != c.match {
+			t.Error("匹配结果不一致\n", c.pattern, c.key, "预期:", c.match, "|", "实际:", actual)
+		}
+	}
+	for _, k := range []string{"Order-created", "order_created", "order-created/x", "x/order-created", "-order", "1order", "order..paid", ".order", "order.", "_dead", "order created"} {
+		if checkEventKey(k) == nil {
+			t.Error("非法的事件key应返回错误:", k)
+		}
+	}
+	for _, k := range []string{"order-created", "order-", "billing.invoice.paid", "v2"} {
+		if err := checkEventKey(k); err != nil {
+			t.Error("合法的事件key不应返回错误:", k, err)
+		}
+	}
+	for _, p := range []string{"order-**", "_dead.*", "a/*", "Order-*", "order.*_x"} {
+		if checkKeyPattern(p) == nil {
+			t.Error("非法的事件key模式应返回错误:", p)
+		}
+	}
+}
+
+func TestSubscribePattern(t *testing.T) {
+	ee := MockEventEngine(t, backends.StoreConfig{})
+	publish(t, ee, "order-created", nil)
+	r := newRecorder()
+	if _, err := ee.Subscribe("order-*", r.handle); err != nil {
+		t.Fatal(err)
+	}
+	r.none(t)
+	publish(t, ee, "order-created", map[string]interface{}{"n": 1})
+	publish(t, ee, "order-paid", map[string]interface{}{"n": 2})
+	publish(t, ee, "user-created", nil)
+	received := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		received[r.next(t).Key] = true
+	}
+	if !received["order-created"] || !received["order-paid"] {
+		t.Error("通配订阅未收到匹配的事件:", received)
+	}
+	r.none(t)
+}
+
+func TestEventTimeUnits(t *testing.T) {
+	e, _ := NewFCEvent("order-created")
+	e.SetPublished()
+	b, _ := e.Json()
+	var stored map[string]interface{}
+	json.Unmarshal(b, &stored)
+	if stored["timeout"] != float64(10000) {
+		t.Error("timeout应以毫秒存储,实际:", stored["timeout"])
+	}
+	if pub := int64(stored["pub_time"].(float64)); pub != e.PubTime.UnixMilli() {
+		t.Error("pub_time应以毫秒存储,实际:", pub)
+	}
+	var decoded Event
+	json.Unmarshal(b, &decoded)
+	if decoded.IsTimeout() || decoded.Timeout != DefaultEventTimeout {
+		t.Errorf("刚发布的事件不应超时:%+v", decoded)
+	}
+	legacy := fmt.Sprintf(`{"key":"order-created","pub_time":%d,"timeout":10000,"body":{},"published":true}`, time.Now().Add(-time.Minute).UnixNano())
+	json.Unmarshal([]byte(legacy), &decoded)
+	if !decoded.IsTimeout() {
+		t.Error("旧格式的纳秒发布时间应被正确识别:", decoded.PubTime)
+	}
+	if decoded.IsTimeoutAt(time.Now(), time.Minute) {
+		t.Error("允许的时钟偏差内不应超时")
+	}
+	// 旧版本以毫秒数传入的超时时间
+	if e, _ := NewFCEventTimeout("order-created", 5000); e.Timeout != 5*time.Second {
+		t.Error("小于1毫秒的超时时间应按毫秒数处理:", e.Timeout)
+	}
+}
+
+func TestExpiredEventNotDelivered(t *testing.T) {
+	ee := MockEventEngine(t, backends.StoreConfig{}, WithServerTime())
+	r := newRecorder()
+	ee.Subscribe("order-created", r.handle)
+	e, _ := NewFCEvent("order-created")
+	e.SetPubTime(time.Now().Add(-time.Hour))
+	b, _ := e.Json()
+	ensurePath(ee.store, ee.keyPath("order-created"))
+	ee.store.Add(ee.keyPath("order-created")+"/"+eventNodePrefix, b, backends.FlagSequence)
+	if ev := r.next(t); time.Since(ev.PubTime) > time.Second {
+		t.Error("应以节点创建时间作为发布时间:", ev.PubTime)
+	}
+	// 默认与旧版本一致，超时的事件同样投递
+	ee = MockEventEngine(t, backends.StoreConfig{})
+	ee.Subscribe("order-created", r.handle)
+	ensurePath(ee.store, ee.keyPath("order-created"))
+	ee.store.Add(ee.keyPath("order-created")+"/"+eventNodePrefix, b, backends.FlagSequence)
+	if ev := r.next(t); !ev.IsTimeout() {
+		t.Error("未开启WithDropExpired时应投递超时的事件:", ev.PubTime)
+	}
+	ee = MockEventEngine(t, backends.StoreConfig{}, WithDropExpired())
+	ee.Subscribe("order-created", r.handle)
+	ensurePath(ee.store, ee.keyPath("order-created"))
+	ee.store.Add(ee.keyPath("order-created")+"/"+eventNodePrefix, b, backends.FlagSequence)
+	r.none(t)
+}
+
+func TestJanitor(t *testing.T) {
+	store, _ := backends.NewMock(backends.StoreConfig{})
+	retention := WithRetention(RetentionPolicy{MaxCount: 2})
+	leader, follower := newEventEngine(store, retention), newEventEngine(store.(*mock.Client).NewSession(), retention)
+	follower.Subscribe("stock-changed", func(ctx context.Context, e Event) error { return nil }, WithGroup("svc"))
+	for i := 0; i < 5; i++ {
+		publish(t, leader, "stock-changed", nil)
+	}
+	time.Sleep(50 * time.Millisecond)
+	stopLeader := leader.StartJanitor(time.Hour)
+	for i := 0; i < 100 && leader.JanitorStats().Runs == 0; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	stopFollower := follower.StartJanitor(0) // interval<=0时使用默认间隔，不能panic
+	defer stopFollower()
+	time.Sleep(20 * time.Millisecond)
+	stopLeader()
+	if children, _ := store.Children(leader.keyPath("stock-changed")); len(children) != 2 {
+		t.Error("应只保留最新的2个事件,实际:", children)
+	}
+	if claims, _ := store.Children(leader.eventPath + "/_groups/svc/stock-changed"); len(claims) != 2 {
+		t.Error("被清理事件的认领节点应被删除,实际:", claims)
+	}
+	stats := leader.JanitorStats()
+	if stats.Runs != 1 || stats.DeletedEvents != 3 || stats.DeletedClaims != 3 {
+		t.Errorf("leader的统计信息不匹配:%+v", stats)
+	}
+	if stats := follower.JanitorStats(); stats.Leader || stats.Runs != 0 {
+		t.Errorf("follower不应执行清理:%+v", stats)
+	}
+}
+
+func TestJanitorSystemDirs(t *testing.T) {
+	store, _ := backends.NewMock(backends.StoreConfig{})
+	ee := newEventEngine(store, WithRetention(RetentionPolicy{ReplyMaxAge: 20 * time.Millisecond, DeadLetterMaxAge: 20 * time.Millisecond, CheckpointMaxAge: 20 * time.Millisecond}))
+	leaked, active := ee.eventPath+"/_replies/leaked", ee.eventPath+"/_replies/active"
+	ensurePath(store, leaked)
+	store.Add(leaked+"/"+replyNodePrefix, []byte("{}"), backends.FlagSequence)
+	if err := ee.deadLetter(Event{Key: "stock-changed"}, "group:svc", errors.New("处理失败"), 1); err != nil {
+		t.Fatal(err)
+	}
+	checkpoint := ee.eventPath + "/_offsets/billing/stock-changed"
+	ensurePath(store, checkpoint)
+	time.Sleep(30 * time.Millisecond)
+	ensurePath(store, active)
+	ee.clean()
+	if children, _ := store.Children(ee.eventPath + "/_replies"); len(children) != 1 || children[0] != "active" {
+		t.Error("只应删除遗留的应答目录,实际:", children)
+	}
+	if letters, _ := ee.DeadLetters("stock-changed"); len(letters) != 0 {
+		t.Error("过期的死信应被删除:", letters)
+	}
+	if exists, _ := store.Exists(checkpoint); exists {
+		t.Error("长时间未更新的检查点应被删除")
+	}
+	if stats := ee.JanitorStats(); stats.DeletedReplies != 1 || stats.DeletedDeadLetters != 1 || stats.DeletedCheckpoints != 1 {
+		t.Errorf("统计信息不匹配:%+v", stats)
+	}
+}
+
+type orderPaid struct {
+	OrderID string  `json:"order_id"`
+	Amount  float64 `json:"amount"`
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string { return "gob" }
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type lazyCodec struct{ gobCodec }
+
+func (lazyCodec) Name() string { return "gob-lazy" }
+
+func TestTypedEvent(t *testing.T) {
+	registered := func(name string) bool {
+		payloadCodecsMu.RLock()
+		defer payloadCodecsMu.RUnlock()
+		_, ok := payloadCodecs[name]
+		return ok
+	}
+	opt := WithPayloadCodec(lazyCodec{})
+	if registered("gob-lazy") {
+		t.Error("只构造选项时不应注册编解码器")
+	}
+	MockEventEngine(t, backends.StoreConfig{}, opt)
+	if !registered("gob-lazy") {
+		t.Error("创建事件引擎时应注册编解码器")
+	}
+	for _, opts := range [][]EventOption{nil, {WithPayloadCodec(gobCodec{})}} {
+		ee := MockEventEngine(t, backends.StoreConfig{}, opts...)
+		typed := make(chan orderPaid, 1)
+		Subscribe(ee, "order-paid", func(ctx context.Context, e TypedEvent[orderPaid]) error {
+			typed <- e.Payload
+			return nil
+		})
+		legacy := newRecorder("order-paid")
+		ee.StartEventListener([]EventListener{legacy})
+		expected := orderPaid{OrderID: "o-1", Amount: 9.5}
+		if _, err := Publish(ee, "order-paid", expected); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case actual := <-typed:
+			if actual != expected {
+				t.Error("类型化数据不匹配\n", "预期:", expected, "|", "实际:", actual)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("未收到类型化事件")
+		}
+		e := legacy.next(t)
+		if opts == nil && e.GetData("order_id") != "o-1" {
+			t.Error("JSON编码的数据应与原有的body格式兼容:", e.Body)
+		}
+	}
+}
+
+func TestEventSizeAndCompression(t *testing.T) {
+	ids := make([]string, 200)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("id-%05d", i)
+	}
+	ee := MockEventEngine(t, backends.StoreConfig{})
+	e, _ := NewFCEvent("ids-changed")
+	e.AddData("ids", ids)
+	var tooLarge *EventTooLargeError
+	if err := ee.CheckSize(e); !errors.As(err, &tooLarge) {
+		t.Error("CheckSize应返回EventTooLargeError,实际:", err)
+	}
+	if err := ee.Publish(e); !errors.As(err, &tooLarge) || tooLarge.Limit != DefaultMaxEventSize {
+		t.Fatal("超过默认大小限制应返回EventTooLargeError,实际:", err)
+	}
+	if e.IsPublished() {
+		t.Error("发布失败的事件不应被标记为已发布")
+	}
+	// 压缩后未超过限制
+	ee = MockEventEngine(t, backends.StoreConfig{}, WithCompression(CompressionGzip))
+	if err := ee.CheckSize(e); err != nil || e.IsPublished() {
+		t.Error("压缩后未超过限制时CheckSize应返回nil且不修改事件:", err)
+	}
+	if err := ee.Publish(e); err != nil {
+		t.Error("压缩后未超过限制时应发布成功:", err)
+	}
+	// 信封元数据不计入大小限制：恰好等于限制时发布成功，多1字节时失败
+	boundary := func(pad int) *Event {
+		e, _ := NewFCEvent("ids-changed")
+		e.AddData("ids", ids[:100])
+		e.AddData("pad", strings.Repeat("x", pad))
+		return e
+	}
+	published := boundary(0)
+	published.SetPublished()
+	b, _ := published.encode(CompressionNone)
+	ee = MockEventEngine(t, backends.StoreConfig{}, WithMaxEventSize(len(b)+10), WithSource("inventory-service"), WithInstance(strings.Repeat("i", 64)))
+	if err := ee.Publish(boundary(10)); err != nil {
+		t.Error("不含元数据恰好等于限制时应发布成功:", err)
+	}
+	if err := ee.Publish(boundary(11)); !errors.As(err, &tooLarge) || tooLarge.Size != len(b)+11 {
+		t.Error("不含元数据超过限制时应返回EventTooLargeError,实际:", err)
+	}
+	for _, c := range []Compression{CompressionNone, CompressionGzip, CompressionSnappy} {
+		ee = MockEventEngine(t, backends.StoreConfig{}, WithMaxEventSize(4096), WithCompression(c))
+		r := newRecorder("ids-changed")
+		ee.StartEventListener([]EventListener{r})
+		publish(t, ee, "ids-changed", map[string]interface{}{"ids": ids})
+		e := r.next(t)
+		if got, _ := e.GetData("ids").([]interface{}); len(got) != len(ids) || got[199] != ids[199] {
+			t.Error("解压后的事件数据不匹配:", c)
+		}
+	}
+}
+
+func TestRequestReply(t *testing.T) {
+	store, _ := backends.NewMock(backends.StoreConfig{})
+	requester := newEventEngine(store)
+	for i := 0; i < 2; i++ {
+		ee := newEventEngine(store.(*mock.Client).NewSession())
+		version := fmt.Sprint("v1.", i)
+		ee.Subscribe("version-query", func(ctx context.Context, e Event) error {
+			return ee.Reply(e, map[string]interface{}{"version": version})
+		})
+	}
+	e, _ := NewFCEvent("version-query")
+	replies, err := requester.Request(e, 200*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := map[interface{}]bool{}
+	for _, r := range replies {
+		versions[r.Body["version"]] = true
+	}
+	if len(replies) != 2 || !versions["v1.0"] || !versions["v1.1"] {
+		t.Errorf("应答不匹配:%+v", replies)
+	}
+	if exists, _ := store.Exists(e.ReplyTo); exists {
+		t.Error("请求结束后应答目录应被删除")
+	}
+	if err = requester.Reply(Event{Key: "version-query"}, nil); err == nil {
+		t.Error("非请求事件不能应答")
+	}
+	if err = requester.Reply(*e, nil); !errors.Is(err, ErrRequestGone) {
+		t.Error("请求超时后应答应返回ErrRequestGone:", err)
+	}
+}
+
+// getCounter 统计Get的调用次数。
+type getCounter struct {
+	backends.StoreClient
+	gets atomic.Int32
+}
+
+func (s *getCounter) Get(path string) ([]byte, *backends.Stat, error) {
+	s.gets.Add(1)
+	return s.StoreClient.Get(path)
+}
+
+func TestReplayFromTimeSeek(t *testing.T) {
+	store, _ := backends.NewMock(backends.StoreConfig{})
+	counter := &getCounter{StoreClient: store}
+	ee := newEventEngine(counter)
+	var since time.Time
+	for i := 1; i <= 64; i++ {
+		if i == 41 {
+			time.Sleep(2 * time.Millisecond)
+			since = time.Now()
+		}
+		publish(t, ee, "stock-changed", nil)
+	}
+	for _, c := range []struct {
+		since time.Time
+		first int64
+	}{{since, 41}, {time.Now().Add(time.Hour), 0}, {time.Time{}, 1}} {
+		counter.gets.Store(0)
+		s := &subscription{engine: ee, store: counter, key: "stock-changed", dir: ee.keyPath("stock-changed"), start: startPosition{mode: startTime, time: c.since}}
+		s.seek(false)
+		if c.first > 0 && s.offset != c.first-1 || c.first == 0 && s.offset != 64 {
+			t.Error("定位的offset不匹配:", c.since, s.offset)
+		}
+		// 二分查找最多读取log2(64)+1个节点
+		if n := counter.gets.Load(); n > 7 {
+			t.Error("定位时读取的节点过多:", n)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	ee := MockEventEngine(t, backends.StoreConfig{})
+	for i := 1; i <= 3; i++ {
+		publish(t, ee, "stock-changed", map[string]interface{}{"n": i})
+	}
+	time.Sleep(10 * time.Millisecond)
+	since := time.Now()
+	publish(t, ee, "stock-changed", map[string]interface{}{"n": 4})
+	expect := func(r *recorder, offsets ...int64) {
+		t.Helper()
+		for _, o := range offsets {
+			if e := r.next(t); e.Offset != o {
+				t.Error("事件offset不匹配\n", "预期:", o, "|", "实际:", e.Offset)
+			}
+		}
+		r.none(t)
+	}
+	for _, c := range []struct {
+		opt     SubscribeOption
+		offsets []int64
+	}{
+		{FromEarliest(), []int64{1, 2, 3, 4}},
+		{FromOffset(2), []int64{3, 4}},
+		{FromTime(since), []int64{4}},
+		{FromTime(time.Now().Add(time.Hour)), nil},
+	} {
+		r := newRecorder()
+		unsubscribe, err := ee.Subscribe("stock-changed", r.handle, c.opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect(r, c.offsets...)
+		unsubscribe()
+	}
+
+	// 检查点：重新订阅时从上次处理的位置继续，且优先于FromEarliest
+	r := newRecorder()
+	unsubscribe, _ := ee.Subscribe("stock-changed", r.handle, FromOffset(3), WithCheckpoint("stock-sync"))
+	expect(r, 4)
+	publish(t, ee, "stock-changed", nil)
+	expect(r, 5)
+	unsubscribe()
+	publish(t, ee, "stock-changed", nil)
+	unsubscribe, _ = ee.Subscribe("stock-changed", r.handle, FromEarliest(), WithCheckpoint("stock-sync"))
+	expect(r, 6)
+	publish(t, ee, "stock-changed", nil)
+	expect(r, 7)
+	unsubscribe()
+	if _, err := ee.Subscribe("stock-changed", r.handle, WithCheckpoint("a/b")); err == nil {
+		t.Error("检查点名称不能包含路径分隔符")
+	}
+}
+
+func TestReplayExpiredEvent(t *testing.T) {
+	ee := MockEventEngine(t, backends.StoreConfig{}, WithDropExpired())
+	e, _ := NewFCEventTimeout("stock-changed", 20*time.Millisecond)
+	if err := ee.Publish(e); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	r := newRecorder()
+	unsubscribe, _ := ee.Subscribe("stock-changed", r.handle, FromEarliest())
+	defer unsubscribe()
+	if ev := r.next(t); ev.Offset != e.Offset {
+		t.Error("重放的事件即使已超时也应投递:", ev.Offset)
+	}
+	// 订阅之后发布的事件仍按超时判断
+	late, _ := NewFCEventTimeout("stock-changed", time.Millisecond)
+	late.SetPubTime(time.Now().Add(-time.Hour))
+	b, _ := late.Json()
+	ee.store.Add(ee.keyPath("stock-changed")+"/"+eventNodePrefix, b, backends.FlagSequence)
+	r.none(t)
+}
+
+func TestEventTracing(t *testing.T) {
+	ee := MockEventEngine(t, backends.StoreConfig{}, WithSource("order-service"), WithInstance("order-1"))
+	traces := make(chan string, 2)
+	r := newRecorder()
+	ee.Subscribe("order-shipped", func(ctx context.Context, e Event) error {
+		traces <- TraceParentFromContext(ctx)
+		return r.handle(ctx, e)
+	})
+	parent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	e, _ := NewFCEvent("order-shipped")
+	if err := ee.PublishContext(ContextWithTraceParent(context.Background(), parent), e); err != nil {
+		t.Fatal(err)
+	}
+	got := r.next(t)
+	if got.ID != e.ID || len(got.ID) != 32 || got.Source != "order-service" || got.Instance != "order-1" || got.TraceParent != parent {
+		t.Errorf("事件追踪信息不匹配:%+v", got)
+	}
+	if tp := <-traces; tp != parent {
+		t.Error("处理函数的ctx应携带traceparent\n", "预期:", parent, "|", "实际:", tp)
+	}
+
+	// 未携带trace时开启新的trace，每个事件的ID不同
+	publish(t, ee, "order-shipped", nil)
+	next := r.next(t)
+	if tp := <-traces; !traceParentPattern.MatchString(tp) || tp == parent || next.ID == got.ID {
+		t.Errorf("应生成新的trace和事件ID:%s %s", tp, next.ID)
+	}
+	if TraceParentFromContext(ContextWithTraceParent(context.Background(), "invalid")) != "" {
+		t.Error("格式不正确的traceparent应被忽略")
+	}
+}
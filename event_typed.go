@@ -0,0 +1,137 @@
+package configuration
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// PayloadCodec 类型化事件数据的编解码器。使用JSON以外的编解码器时，事件body中只包含编解码器名称和编码后的数据，
+// 接收方需注册同名的编解码器才能解码。
+type PayloadCodec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+const (
+	payloadCodecField = "_codec" // body中记录编解码器名称的字段
+	payloadDataField  = "_data"  // body中记录编码后数据(base64)的字段
+)
+
+// JSONCodec 默认的编解码器，数据编码为JSON对象后直接作为事件的body，与未使用类型化数据的事件格式完全兼容。
+type JSONCodec struct{}
+
+func (JSONCodec) Name() string {
+	return "json"
+}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+var (
+	payloadCodecsMu sync.RWMutex
+	payloadCodecs   = map[string]PayloadCodec{"json": JSONCodec{}}
+)
+
+// RegisterPayloadCodec 注册事件数据编解码器，接收方按事件中记录的名称查找编解码器。
+func RegisterPayloadCodec(codec PayloadCodec) {
+	payloadCodecsMu.Lock()
+	defer payloadCodecsMu.Unlock()
+	payloadCodecs[codec.Name()] = codec
+}
+
+// WithPayloadCodec 设置Publish[T]使用的编解码器，默认为JSONCodec；创建事件引擎时同时注册到编解码器列表中，只构造选项不会注册。
+func WithPayloadCodec(codec PayloadCodec) EventOption {
+	return func(ee *eventEngine) {
+		RegisterPayloadCodec(codec)
+		ee.codec = codec
+	}
+}
+
+// TypedEvent 携带类型化数据的事件。
+type TypedEvent[T any] struct {
+	Event
+	Payload T
+}
+
+// Publish 将类型化的数据作为指定key的事件发布，数据使用事件引擎的编解码器编码。
+func Publish[T any](ee *eventEngine, key string, payload T) (*Event, error) {
+	e, err := NewFCEvent(key)
+	if err != nil {
+		return nil, err
+	}
+	if e.Body, err = encodePayload(ee.payloadCodec(), payload); err != nil {
+		return nil, err
+	}
+	return e, ee.Publish(e)
+}
+
+// Subscribe 订阅指定key的类型化事件，事件数据解码失败时按处理失败对待。
+func Subscribe[T any](ee *eventEngine, key string, handler func(ctx context.Context, e TypedEvent[T]) error, opts ...SubscribeOption) (func(), error) {
+	opts = append([]SubscribeOption{WithName(handlerName(handler))}, opts...)
+	return ee.Subscribe(key, func(ctx context.Context, e Event) error {
+		payload, err := PayloadOf[T](e)
+		if err != nil {
+			return err
+		}
+		return handler(ctx, TypedEvent[T]{Event: e, Payload: payload})
+	}, opts...)
+}
+
+// PayloadOf 将事件的数据解码为指定的类型。
+func PayloadOf[T any](e Event) (T, error) {
+	var payload T
+	if name, ok := e.Body[payloadCodecField].(string); ok {
+		payloadCodecsMu.RLock()
+		codec, ok := payloadCodecs[name]
+		payloadCodecsMu.RUnlock()
+		if !ok {
+			return payload, fmt.Errorf("事件[%s]的数据编解码器[%s]未注册", e.Key, name)
+		}
+		data, _ := e.Body[payloadDataField].(string)
+		b, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return payload, fmt.Errorf("事件[%s]的数据格式错误:%w", e.Key, err)
+		}
+		return payload, codec.Unmarshal(b, &payload)
+	}
+	raw := e.rawBody
+	if len(raw) == 0 {
+		var err error
+		if raw, err = json.Marshal(e.Body); err != nil {
+			return payload, err
+		}
+	}
+	return payload, json.Unmarshal(raw, &payload)
+}
+
+func (ee *eventEngine) payloadCodec() PayloadCodec {
+	if ee.codec == nil {
+		return JSONCodec{}
+	}
+	return ee.codec
+}
+
+// encodePayload 将数据编码为事件的body。
+func encodePayload(codec PayloadCodec, payload interface{}) (map[string]interface{}, error) {
+	b, err := codec.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := codec.(JSONCodec); !ok {
+		return map[string]interface{}{payloadCodecField: codec.Name(), payloadDataField: base64.StdEncoding.EncodeToString(b)}, nil
+	}
+	body := make(map[string]interface{})
+	if err = json.Unmarshal(b, &body); err != nil {
+		return nil, fmt.Errorf("使用JSON编码的事件数据必须是JSON对象:%w", err)
+	}
+	return body, nil
+}
@@ -1,11 +1,15 @@
 module github.com/aluka-7/configuration
 
-go 1.18
+go 1.19
 
 require (
+	github.com/BurntSushi/toml v1.3.2
 	github.com/aluka-7/utils v1.0.1
+	github.com/golang/snappy v1.0.0
 	github.com/rs/zerolog v1.27.0
 	github.com/samuel/go-zookeeper v0.0.0-20201211165307-7117e9ea2414
+	github.com/santhosh-tekuri/jsonschema/v5 v5.3.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,252 @@
+package configuration
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// maxInterpolateDepth 引用展开的最大嵌套层数，超过时视为配置错误。
+const maxInterpolateDepth = 16
+
+var refPattern = regexp.MustCompile(`\$\$|\$\{([^}]*)\}`)
+
+// WithInterpolation 开启变量插值：String/Values/Clazz/Unmarshal/Get/Bind读取的配置数据中，
+// ${app/group/path}替换为Namespace下对应配置项的值(被引用的配置项同样会展开)，${env:NAME}替换为环境变量，
+// ${key:-default}在引用的配置项或环境变量不存在时使用default，$$表示字面量$。
+// 引用不存在且没有默认值、循环引用时读取返回错误；Get和Bind同时监听被引用的配置项，其变化也会触发通知。
+func WithInterpolation() Option {
+	return func(c *configuration) {
+		c.interpolate = true
+	}
+}
+
+// getValues 批量获取配置项，candidates为每个配置项按优先级从高到低的候选路径(分层查找的各层)。
+// 开启变量插值时展开配置数据中的引用，并返回所有被引用的配置项路径(已排序)：merge为false时只展开每组中第一个存在的路径，
+// 其余的层不会被使用，不展开也不在结果中，因此被覆盖的层中无法解析的引用(如不存在的环境变量)不会导致读取失败；
+// merge为true时各层都参与合并，展开所有存在的路径。同一次调用中每个被引用的配置项只读取和展开一次。
+func (c configuration) getValues(candidates [][]string, merge bool) (map[string]string, []string, error) {
+	vl, err := c.store.GetValues(flatten(candidates))
+	if err != nil || !c.interpolate {
+		return vl, nil, err
+	}
+	selected := make(map[string]string, len(candidates))
+	for _, paths := range candidates {
+		for _, p := range paths {
+			if v, ok := vl[p]; ok {
+				selected[p] = v
+				if !merge {
+					break
+				}
+			}
+		}
+	}
+	x := &expansion{conf: c, refs: make(map[string]bool), values: make(map[string]*string)}
+	for k, v := range selected {
+		if selected[k], err = x.expand(v, []string{k}); err != nil {
+			return nil, nil, err
+		}
+	}
+	list := make([]string, 0, len(x.refs))
+	for r := range x.refs {
+		list = append(list, r)
+	}
+	sort.Strings(list)
+	return selected, list, nil
+}
+
+// flatten 按顺序合并各组候选路径。
+func flatten(candidates [][]string) []string {
+	paths := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		paths = append(paths, c...)
+	}
+	return paths
+}
+
+// expansion 一次getValues中的引用展开，refs收集所有被引用的配置项路径，values缓存已展开的被引用配置项(nil表示不存在)。
+type expansion struct {
+	conf   configuration
+	refs   map[string]bool
+	values map[string]*string
+}
+
+// expand 展开value中的引用，stack为当前的引用链(用于检测循环引用)。
+func (x *expansion) expand(value string, stack []string) (string, error) {
+	if !strings.Contains(value, "$") {
+		return value, nil
+	}
+	var err error
+	out := refPattern.ReplaceAllStringFunc(value, func(m string) string {
+		if err != nil {
+			return m
+		}
+		if m == "$$" {
+			return "$"
+		}
+		var s string
+		s, err = x.reference(m[2:len(m)-1], stack)
+		return s
+	})
+	return out, err
+}
+
+// reference 解析单个引用${expr}的值。
+func (x *expansion) reference(expr string, stack []string) (string, error) {
+	name, def, hasDefault := strings.Cut(expr, ":-")
+	if strings.HasPrefix(name, "env:") {
+		env := strings.TrimPrefix(name, "env:")
+		if v, ok := os.LookupEnv(env); ok {
+			return v, nil
+		}
+		if hasDefault {
+			return def, nil
+		}
+		return "", fmt.Errorf("配置项[%s]引用的环境变量[%s]不存在", stack[len(stack)-1], env)
+	}
+	path := Namespace + "/" + strings.Trim(name, "/")
+	x.refs[path] = true
+	for _, p := range stack {
+		if p == path {
+			return "", fmt.Errorf("配置项存在循环引用:%s -> %s", strings.Join(stack, " -> "), path)
+		}
+	}
+	if len(stack) > maxInterpolateDepth {
+		return "", fmt.Errorf("配置项[%s]的引用层数超过%d", stack[0], maxInterpolateDepth)
+	}
+	v, cached := x.values[path]
+	if !cached {
+		vl, err := x.conf.store.GetValues([]string{path})
+		if err != nil {
+			return "", err
+		}
+		if raw, ok := vl[path]; ok {
+			expanded, err := x.expand(raw, append(stack, path))
+			if err != nil {
+				return "", err
+			}
+			v = &expanded
+		}
+		x.values[path] = v
+	}
+	if v == nil {
+		if hasDefault {
+			return def, nil
+		}
+		return "", fmt.Errorf("配置项[%s]引用的%w", stack[len(stack)-1], notFound([]string{path}))
+	}
+	return *v, nil
+}
+
+// armedValues 先对candidates的所有路径设置sentinel再读取(merge同getValues)，之后发生的变化都不会丢失；
+// 引用了尚未设置监听的配置项时对其设置监听后重新读取。
+func (c configuration) armedValues(candidates [][]string, merge bool) (map[string]string, []string, *sentinel, error) {
+	paths := flatten(candidates)
+	s := newSentinel()
+	s.arm(c.store, paths)
+	armed := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		armed[path] = true
+	}
+	for {
+		vl, refs, err := c.getValues(candidates, merge)
+		if err != nil {
+			s.fire()
+			return nil, nil, nil, err
+		}
+		pending := make([]string, 0)
+		for _, ref := range refs {
+			if !armed[ref] {
+				armed[ref] = true
+				pending = append(pending, ref)
+			}
+		}
+		if len(pending) == 0 {
+			return vl, refs, s, nil
+		}
+		s.arm(c.store, pending)
+	}
+}
+
+// watch 监听candidates的所有路径并在变化时通知listener，开启变量插值时同时监听被引用的配置项，
+// listener收到的是按getValues(candidates, merge)展开后的配置数据。
+// sentinel为读取配置之前通过armedValues设置的监听，监听建立之前发生的变化会重新读取并通知。
+func (c configuration) watch(candidates [][]string, merge bool, refs []string, sentinel *sentinel, listener ChangedListener) Processor {
+	var p Processor
+	if c.interpolate {
+		p = &interpolatingProcessor{conf: c, candidates: candidates, merge: merge, refs: refs, sentinel: sentinel, stopChan: make(chan bool)}
+	} else {
+		p = newWatchProcessor(flatten(candidates), c.store, sentinel)
+	}
+	go p.Process(listener)
+	return p
+}
+
+// interpolatingProcessor 监听配置项及其引用的配置项，引用关系变化时按新的引用重新监听。
+type interpolatingProcessor struct {
+	conf       configuration
+	candidates [][]string
+	merge      bool
+	mu         sync.Mutex
+	refs       []string
+	sentinel   *sentinel // 只用于第一次监听
+	stopChan   chan bool
+	stopOnce   sync.Once
+}
+
+func (p *interpolatingProcessor) Process(listener ChangedListener) {
+	for {
+		p.mu.Lock()
+		refs := p.refs
+		p.mu.Unlock()
+		watcher := newWatchProcessor(append(flatten(p.candidates), refs...), p.conf.store, p.sentinel)
+		p.sentinel = nil
+		done := make(chan bool)
+		go func() {
+			defer close(done)
+			watcher.Process(&interpolatingListener{p: p, watcher: watcher, listener: listener})
+		}()
+		select {
+		case <-p.stopChan:
+			watcher.Stop()
+			<-done
+			return
+		case <-done:
+			// 引用关系变化，按新的引用重新监听
+		}
+	}
+}
+
+func (p *interpolatingProcessor) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stopChan)
+	})
+}
+
+type interpolatingListener struct {
+	p        *interpolatingProcessor
+	watcher  Processor
+	listener ChangedListener
+}
+
+// Changed 重新获取并展开配置数据，展开失败时保留上一次的通知结果。
+func (l *interpolatingListener) Changed(map[string]string) {
+	vl, refs, err := l.p.conf.getValues(l.p.candidates, l.p.merge)
+	if err != nil {
+		log.Err(err).Msgf("展开配置项[%v]的引用出错:%+v", l.p.candidates, err)
+		return
+	}
+	l.listener.Changed(vl)
+	l.p.mu.Lock()
+	changed := strings.Join(refs, ",") != strings.Join(l.p.refs, ",")
+	l.p.refs = refs
+	l.p.mu.Unlock()
+	if changed {
+		l.watcher.Stop()
+	}
+}
@@ -0,0 +1,286 @@
+package configuration
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aluka-7/configuration/backends"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	janitorLock            = "_janitor"
+	defaultReplyMaxAge     = time.Hour
+	defaultJanitorInterval = time.Minute
+)
+
+// RetentionPolicy 事件的保留策略，超过MaxAge或超出每个key最多保留MaxCount个的旧事件会被清理，0表示不限制。
+type RetentionPolicy struct {
+	MaxAge           time.Duration // 事件最长保留时间，以节点创建时间计算
+	MaxCount         int           // 每个key最多保留的事件数
+	ReplyMaxAge      time.Duration // Request的应答目录最长保留时间(请求方崩溃时遗留的)，以节点创建时间计算，0时为1小时
+	DeadLetterMaxAge time.Duration // 死信最长保留时间，以节点创建时间计算，0表示不清理
+	CheckpointMaxAge time.Duration // 检查点超过此时间未更新则删除，0表示不清理
+}
+
+// WithRetention 设置事件的保留策略，由StartJanitor启动的后台清理任务执行。
+func WithRetention(policy RetentionPolicy) EventOption {
+	return func(ee *eventEngine) {
+		ee.retention = policy
+	}
+}
+
+// JanitorStats 事件清理任务的统计信息。
+type JanitorStats struct {
+	Leader             bool      // 当前实例是否持有清理任务的leader锁
+	Runs               uint64    // 作为leader执行清理的次数
+	DeletedEvents      uint64    // 累计删除的事件节点数
+	DeletedClaims      uint64    // 累计删除的消费组认领节点数
+	DeletedReplies     uint64    // 累计删除的应答目录数
+	DeletedDeadLetters uint64    // 累计删除的死信数
+	DeletedCheckpoints uint64    // 累计删除的检查点数
+	Errors             uint64    // 累计出错次数
+	LastRun            time.Time // 最后一次执行清理的时间
+}
+
+type janitor struct {
+	mu    sync.Mutex
+	stats JanitorStats
+}
+
+// JanitorStats 获取事件清理任务的统计信息。
+func (ee *eventEngine) JanitorStats() JanitorStats {
+	ee.janitor.mu.Lock()
+	defer ee.janitor.mu.Unlock()
+	return ee.janitor.stats
+}
+
+// StartJanitor 启动后台清理任务，每隔interval按保留策略清理过期事件及其消费组认领节点、遗留的应答目录、过期的死信和检查点。
+// 所有实例都可以启动清理任务，但只有持有/system_events/_janitor锁的实例(leader)会执行清理，
+// leader的会话失效后由其他实例接替。interval<=0时为1分钟。返回的函数用于停止清理任务并释放leader锁。
+func (ee *eventEngine) StartJanitor(interval time.Duration) func() {
+	if interval <= 0 {
+		log.Warn().Msgf("事件清理任务的间隔[%v]无效，使用默认间隔%v", interval, defaultJanitorInterval)
+		interval = defaultJanitorInterval
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lock := ee.store.Lock(ee.eventPath + "/" + janitorLock)
+		leader := false
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if leader {
+				select {
+				case <-lock.Lost():
+					lock.Unlock()
+					leader = false
+				default:
+				}
+			}
+			if !leader {
+				ok, err := lock.TryLock()
+				if err != nil {
+					log.Err(err).Msg("获取事件清理任务的leader锁出错")
+				}
+				leader = ok
+			}
+			ee.janitor.mu.Lock()
+			ee.janitor.stats.Leader = leader
+			ee.janitor.mu.Unlock()
+			if leader {
+				ee.clean()
+			}
+			select {
+			case <-ticker.C:
+			case <-stop:
+				if leader {
+					lock.Unlock()
+				}
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			<-done
+		})
+	}
+}
+
+// clean 按保留策略执行一次清理。
+func (ee *eventEngine) clean() {
+	keys, err := ee.store.Children(ee.eventPath)
+	if err != nil && err != backends.ErrNoNode {
+		ee.janitorError(err, "获取事件目录出错")
+		return
+	}
+	var events, claims uint64
+	for _, key := range keys {
+		if strings.HasPrefix(key, "_") {
+			continue
+		}
+		n, cutoff, err := ee.cleanKey(key)
+		events += n
+		if err != nil {
+			ee.janitorError(err, fmt.Sprintf("清理事件[%s]出错", key))
+		}
+		if cutoff > 0 {
+			c, err := ee.cleanClaims(key, cutoff)
+			claims += c
+			if err != nil {
+				ee.janitorError(err, fmt.Sprintf("清理事件[%s]的认领节点出错", key))
+			}
+		}
+	}
+	replyMaxAge := ee.retention.ReplyMaxAge
+	if replyMaxAge <= 0 {
+		replyMaxAge = defaultReplyMaxAge
+	}
+	replies, err := ee.cleanAged(ee.eventPath+"/"+replyDir, 1, replyMaxAge, false)
+	if err != nil {
+		ee.janitorError(err, "清理应答目录出错")
+	}
+	dead, err := ee.cleanAged(ee.eventPath+"/"+deadLetterDir, 2, ee.retention.DeadLetterMaxAge, false)
+	if err != nil {
+		ee.janitorError(err, "清理死信出错")
+	}
+	checkpoints, err := ee.cleanAged(ee.eventPath+"/"+offsetDir, 2, ee.retention.CheckpointMaxAge, true)
+	if err != nil {
+		ee.janitorError(err, "清理检查点出错")
+	}
+	if events > 0 || claims > 0 || replies > 0 || dead > 0 || checkpoints > 0 {
+		log.Info().Msgf("清理过期事件%d个，认领节点%d个，应答目录%d个，死信%d个，检查点%d个", events, claims, replies, dead, checkpoints)
+	}
+	ee.janitor.mu.Lock()
+	defer ee.janitor.mu.Unlock()
+	ee.janitor.stats.Runs++
+	ee.janitor.stats.DeletedEvents += events
+	ee.janitor.stats.DeletedClaims += claims
+	ee.janitor.stats.DeletedReplies += replies
+	ee.janitor.stats.DeletedDeadLetters += dead
+	ee.janitor.stats.DeletedCheckpoints += checkpoints
+	ee.janitor.stats.LastRun = time.Now()
+}
+
+// cleanAged 删除dir下第depth层中超过maxAge的节点(连同其子节点)，modified为true时以修改时间计算，否则以创建时间计算，maxAge<=0时不清理。
+func (ee *eventEngine) cleanAged(dir string, depth int, maxAge time.Duration, modified bool) (uint64, error) {
+	if maxAge <= 0 {
+		return 0, nil
+	}
+	children, err := ee.store.Children(dir)
+	if err == backends.ErrNoNode {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var deleted uint64
+	for _, c := range children {
+		path := dir + "/" + c
+		if depth > 1 {
+			n, err := ee.cleanAged(path, depth-1, maxAge, modified)
+			deleted += n
+			if err != nil {
+				return deleted, err
+			}
+			continue
+		}
+		_, stat, err := ee.store.Get(path)
+		if err == backends.ErrNoNode {
+			continue
+		}
+		if err != nil {
+			return deleted, err
+		}
+		t := stat.Ctime
+		if modified {
+			t = stat.Mtime
+		}
+		if time.Since(t) > maxAge {
+			ee.deleteTree(path)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// cleanKey 清理一个key下过期的事件，返回删除的节点数和被删除事件的最大offset。
+func (ee *eventEngine) cleanKey(key string) (uint64, int64, error) {
+	dir := ee.keyPath(key)
+	children, err := ee.store.Children(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	nodes := sortSeq(children)
+	var deleted uint64
+	var cutoff int64
+	for i, n := range nodes {
+		expired := ee.retention.MaxCount > 0 && len(nodes)-i > ee.retention.MaxCount
+		if !expired && ee.retention.MaxAge > 0 {
+			_, stat, err := ee.store.Get(dir + "/" + n.name)
+			if err == backends.ErrNoNode {
+				continue
+			}
+			if err != nil {
+				return deleted, cutoff, err
+			}
+			expired = time.Since(stat.Ctime) > ee.retention.MaxAge
+		}
+		if !expired {
+			// 顺序节点按创建时间排列，之后的事件都未过期
+			break
+		}
+		if err = ee.store.Delete(dir + "/" + n.name); err != nil && err != backends.ErrNoNode {
+			return deleted, cutoff, err
+		}
+		deleted++
+		cutoff = n.seq
+	}
+	return deleted, cutoff, nil
+}
+
+// cleanClaims 删除所有消费组中offset不大于cutoff的认领节点。
+func (ee *eventEngine) cleanClaims(key string, cutoff int64) (uint64, error) {
+	groups, err := ee.store.Children(ee.eventPath + "/" + groupDir)
+	if err == backends.ErrNoNode {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var deleted uint64
+	for _, g := range groups {
+		dir := ee.eventPath + "/" + groupDir + "/" + g + "/" + key
+		children, err := ee.store.Children(dir)
+		if err == backends.ErrNoNode {
+			continue
+		}
+		if err != nil {
+			return deleted, err
+		}
+		for _, n := range sortSeq(children) {
+			if n.seq > cutoff {
+				break
+			}
+			if err = ee.store.Delete(dir + "/" + n.name); err != nil && err != backends.ErrNoNode {
+				return deleted, err
+			}
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (ee *eventEngine) janitorError(err error, msg string) {
+	log.Err(err).Msg(msg)
+	ee.janitor.mu.Lock()
+	ee.janitor.stats.Errors++
+	ee.janitor.mu.Unlock()
+}
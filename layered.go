@@ -0,0 +1,100 @@
+package configuration
+
+import (
+	"fmt"
+
+	"github.com/aluka-7/configuration/backends"
+	"github.com/rs/zerolog/log"
+)
+
+// Option 配置管理引擎的选项。
+type Option func(c *configuration)
+
+// WithLayeredLookup 开启分层查找：String/Values/Clazz等读取配置时先查找tag下的配置项，不存在时回退到不带tag的配置项，
+// 因此tag(如环境名prod)下只需存储与默认配置不同的配置项。
+func WithLayeredLookup() Option {
+	return func(c *configuration) {
+		c.layered = true
+	}
+}
+
+// WithCommonGroup 开启分层查找，并在tag和不带tag的配置项都不存在时回退到同一app下group分组的配置项，用于存放app级别的公共配置。
+func WithCommonGroup(group string) Option {
+	return func(c *configuration) {
+		c.layered = true
+		c.common = group
+	}
+}
+
+// Resolved 分层查找的结果。
+type Resolved struct {
+	Value  string // 配置数据
+	Source string // 配置数据实际来源的完整路径
+}
+
+// layers 配置项按优先级从高到低的候选路径，未开启分层查找时只有maskPath一个。
+func (c configuration) layers(app, group, tag, path string) []string {
+	paths := []string{c.maskPath(app, group, tag, path)}
+	if !c.layered {
+		return paths
+	}
+	if len(tag) > 0 {
+		paths = append(paths, c.maskPath(app, group, "", path))
+	}
+	if len(c.common) > 0 && c.common != group {
+		paths = append(paths, c.maskPath(app, c.common, "", path))
+	}
+	return paths
+}
+
+// Resolve 按分层查找批量获取多个配置项，结果以maskPath(app, group, tag, path)为key，并给出配置数据的实际来源，
+// 所有候选路径都不存在的配置项不在结果中。
+func (c configuration) Resolve(app, group, tag string, path []string) (map[string]Resolved, error) {
+	candidates := make([][]string, len(path))
+	for i, p := range path {
+		candidates[i] = c.layers(app, group, tag, p)
+	}
+	vl, _, err := c.getValues(candidates, false)
+	if err != nil {
+		log.Err(err).Msgf("获取多个配置项[%v]的配置信息出错:%+v", candidates, err)
+		return nil, err
+	}
+	return resolveLayers(candidates, vl), nil
+}
+
+// resolveLayers 为每组候选路径选取第一个存在的配置项，以第一个候选路径为key。
+func resolveLayers(candidates [][]string, vl map[string]string) map[string]Resolved {
+	r := make(map[string]Resolved, len(candidates))
+	for _, paths := range candidates {
+		for _, p := range paths {
+			if v, ok := vl[p]; ok {
+				r[paths[0]] = Resolved{Value: v, Source: p}
+				break
+			}
+		}
+	}
+	return r
+}
+
+// layeredListener 监听所有候选路径，配置变化时将分层查找的结果通知给listener。
+type layeredListener struct {
+	candidates [][]string
+	listener   ChangedListener
+}
+
+func (l *layeredListener) Changed(data map[string]string) {
+	r := resolveLayers(l.candidates, data)
+	vl := make(map[string]string, len(r))
+	for k, v := range r {
+		vl[k] = v.Value
+	}
+	l.listener.Changed(vl)
+}
+
+// notFound 配置项所有候选路径都不存在时的错误。
+func notFound(paths []string) error {
+	if len(paths) == 1 {
+		return fmt.Errorf("配置项[%s]不存在:%w", paths[0], backends.ErrNoNode)
+	}
+	return fmt.Errorf("配置项[%s]不存在(已查找%v):%w", paths[0], paths, backends.ErrNoNode)
+}
@@ -0,0 +1,113 @@
+package configuration
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// MergeStrategy 分层查找时多个层都存在同一配置项的合并策略，用于Clazz和Bind。
+type MergeStrategy int
+
+const (
+	// MergeReplace 使用优先级最高的配置项，不合并(默认)。
+	MergeReplace MergeStrategy = iota
+	// MergeDeep 逐字段深度合并对象，优先级高的层覆盖同名字段，数组整体替换。
+	MergeDeep
+	// MergeAppend 同MergeDeep，但数组按优先级从低到高拼接。
+	MergeAppend
+)
+
+// WithMergeStrategy 设置引擎默认的合并策略，需同时开启分层查找。
+func WithMergeStrategy(strategy MergeStrategy) Option {
+	return func(c *configuration) {
+		c.merge = strategy
+	}
+}
+
+// WithMerge 指定本次解码使用的合并策略，覆盖引擎默认的合并策略。
+func WithMerge(strategy MergeStrategy) DecodeOption {
+	return func(o *decodeOptions) {
+		o.merge = &strategy
+	}
+}
+
+// document 按合并策略从各层(按优先级从高到低)的配置数据得到待解码的配置：不合并或只有一层存在时为优先级最高的配置项，
+// 否则各层分别解码后合并为JSON，Source为参与合并的路径(以,分隔)，返回的opts追加了JSONCodec。
+// 只有每一层都能解码为对象或数组时才合并，否则(如标量配置项)同样使用优先级最高的配置项。
+func (c configuration) document(layers []string, vl map[string]string, opts []DecodeOption) (Resolved, []DecodeOption, bool, error) {
+	strategy := c.strategy(opts)
+	present := make([]string, 0, len(layers))
+	for _, p := range layers {
+		if _, ok := vl[p]; ok {
+			present = append(present, p)
+		}
+	}
+	if len(present) == 0 {
+		return Resolved{}, opts, false, nil
+	}
+	if strategy == MergeReplace || len(present) == 1 {
+		return Resolved{Value: vl[present[0]], Source: present[0]}, opts, true, nil
+	}
+	values := make([]interface{}, len(present))
+	for i, p := range present {
+		if err := decode(p, []byte(vl[p]), &values[i], opts); err != nil || !structured(values[i]) {
+			return Resolved{Value: vl[present[0]], Source: present[0]}, opts, true, nil
+		}
+	}
+	var merged interface{}
+	for i := len(values) - 1; i >= 0; i-- {
+		merged = mergeValue(merged, values[i], strategy)
+	}
+	b, err := json.Marshal(merged)
+	if err != nil {
+		return Resolved{}, opts, true, err
+	}
+	opts = append(append([]DecodeOption{}, opts...), WithCodec(JSONCodec{}))
+	return Resolved{Value: string(b), Source: strings.Join(present, ",")}, opts, true, nil
+}
+
+// strategy 本次解码使用的合并策略，WithMerge覆盖引擎默认的合并策略。
+func (c configuration) strategy(opts []DecodeOption) MergeStrategy {
+	if o := newDecodeOptions(opts); o.merge != nil {
+		return *o.merge
+	}
+	return c.merge
+}
+
+// structured 判断解码后的值是否为对象或数组。
+func structured(v interface{}) bool {
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// mergeValue 将优先级更高的src合并到dst上，返回合并后的新值，dst和src不会被修改。
+func mergeValue(dst, src interface{}, strategy MergeStrategy) interface{} {
+	if dm, ok := dst.(map[string]interface{}); ok {
+		if sm, ok := src.(map[string]interface{}); ok {
+			out := make(map[string]interface{}, len(dm)+len(sm))
+			for k, v := range dm {
+				out[k] = v
+			}
+			for k, v := range sm {
+				if ov, ok := out[k]; ok {
+					out[k] = mergeValue(ov, v, strategy)
+				} else {
+					out[k] = v
+				}
+			}
+			return out
+		}
+	}
+	if strategy == MergeAppend {
+		if da, ok := dst.([]interface{}); ok {
+			if sa, ok := src.([]interface{}); ok {
+				return append(append(make([]interface{}, 0, len(da)+len(sa)), da...), sa...)
+			}
+		}
+	}
+	return src
+}
@@ -15,6 +15,8 @@ type ChangedListener interface {
 
 type Processor interface {
 	Process(listener ChangedListener)
+	// Stop 停止监听，Process随之返回。
+	Stop()
 }
 
 type EndpointCacher interface {
@@ -29,41 +31,133 @@ type watchProcessor struct {
 	doneChan chan bool
 	errChan  chan error
 	wg       sync.WaitGroup
+	stopOnce sync.Once
 	store    backends.StoreClient
+	sentinel *sentinel // 读取配置之前设置的监听，为nil表示没有
 }
 
 func WatchProcessor(path []string, store backends.StoreClient) Processor {
+	return newWatchProcessor(path, store, nil)
+}
+
+// newWatchProcessor 创建监听处理器，sentinel为读取配置之前设置的监听(见armedValues)，
+// 处理器的监听建立之前sentinel被触发时重新读取并通知，避免丢失读取之后、监听建立之前的变化。
+func newWatchProcessor(path []string, store backends.StoreClient, sentinel *sentinel) *watchProcessor {
 	stopChan := make(chan bool)
 	doneChan := make(chan bool)
 	errChan := make(chan error, 10)
-	var wg sync.WaitGroup
-	return &watchProcessor{path, stopChan, doneChan, errChan, wg, store}
+	return &watchProcessor{path: path, stopChan: stopChan, doneChan: doneChan, errChan: errChan, store: store, sentinel: sentinel}
 }
 
-func (p watchProcessor) Process(listener ChangedListener) {
+func (p *watchProcessor) Process(listener ChangedListener) {
 	var lastIndex uint64
 	defer close(p.doneChan)
+	p.wg.Add(1)
 	go p.monitorPrefix(p.path, lastIndex, listener)
 	p.wg.Wait()
 }
 
-func (p watchProcessor) monitorPrefix(path []string, lastIndex uint64, listener ChangedListener) {
+func (p *watchProcessor) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stopChan)
+	})
+}
+
+func (p *watchProcessor) monitorPrefix(path []string, lastIndex uint64, listener ChangedListener) {
 	defer p.wg.Done()
+	defer p.sentinel.fire()
 	for {
-		index, err := p.store.WatchPrefix(path, lastIndex, p.stopChan)
+		stopChan := p.stopChan
+		if p.sentinel != nil && lastIndex > 0 {
+			// 第一次等待变化时sentinel被触发则中止等待并重新读取
+			stopChan = make(chan bool)
+			go func(s *sentinel) {
+				select {
+				case <-s.fired:
+				case <-p.stopChan:
+				}
+				close(stopChan)
+			}(p.sentinel)
+		}
+		index, err := p.store.WatchPrefix(path, lastIndex, stopChan)
+		if p.stopped() {
+			return
+		}
+		if stopChan != p.stopChan {
+			// 处理器的监听已建立过，不再需要sentinel
+			p.sentinel.fire()
+			p.sentinel = nil
+		}
 		if err != nil {
-			p.errChan <- err
+			p.error(err)
 			//防止后端错误占用所有资源.
-			time.Sleep(time.Second * 2)
+			select {
+			case <-time.After(time.Second * 2):
+			case <-p.stopChan:
+				return
+			}
 			continue
 		}
 		if lastIndex > 0 {
 			if vl, err := p.store.GetValues(path); err == nil {
 				listener.Changed(vl)
 			} else {
-				p.errChan <- err
+				p.error(err)
 			}
 		}
 		lastIndex = index
 	}
 }
+
+func (p *watchProcessor) stopped() bool {
+	select {
+	case <-p.stopChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// sentinel 读取配置之前对配置项设置的一次性监听，任意一个配置项创建、修改或删除时fired被关闭。
+type sentinel struct {
+	fired chan struct{}
+	once  sync.Once
+}
+
+func newSentinel() *sentinel {
+	return &sentinel{fired: make(chan struct{})}
+}
+
+// arm 在读取配置之前对paths设置一次性监听，设置监听出错的配置项忽略(处理器的监听同样会出错并重试)。
+func (s *sentinel) arm(store backends.StoreClient, paths []string) {
+	for _, path := range paths {
+		_, ch, err := store.ExistsW(path)
+		if err != nil {
+			continue
+		}
+		go func(ch <-chan backends.Event) {
+			select {
+			case <-ch:
+				s.fire()
+			case <-s.fired:
+			}
+		}(ch)
+	}
+}
+
+// fire 关闭fired，等待各配置项监听的协程随之退出，s为nil时忽略。
+func (s *sentinel) fire() {
+	if s != nil {
+		s.once.Do(func() {
+			close(s.fired)
+		})
+	}
+}
+
+// error 记录监听出错，错误通道已满时丢弃，避免阻塞监听。
+func (p *watchProcessor) error(err error) {
+	select {
+	case p.errChan <- err:
+	default:
+	}
+}
@@ -0,0 +1,245 @@
+package configuration
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aluka-7/configuration/backends"
+	"github.com/aluka-7/configuration/backends/types"
+)
+
+// RWLock 基于临时顺序节点实现的分布式读写锁，允许多个读者同时持有读锁，写锁则与所有读锁/写锁互斥。
+// 读者只需等待序号比自己小的写者，写者需等待所有序号比自己小的节点，因此按申请顺序公平获取，不会饿死写者。
+// 会话失效时临时节点被删除，锁随之释放。同一个RWLock实例不可重入，每个持有者应各自获取实例。
+type RWLock struct {
+	store     backends.StoreClient
+	path      string
+	mu        sync.Mutex // 只保护以下字段，等待锁时不持有
+	readNode  string
+	writeNode string
+	reading   bool // 正在获取读锁
+	writing   bool // 正在获取写锁
+}
+
+// RLock 获取读锁，直到获取成功、出错或ctx结束。
+func (l *RWLock) RLock(ctx context.Context) error {
+	if !beginAcquire(&l.mu, &l.readNode, &l.reading) {
+		return backends.ErrDeadlock
+	}
+	node, err := acquireSeq(ctx, l.store, l.path, "read-", true, func(nodes []seqNode, i int) (string, bool) {
+		// 等待序号比自己小的最近一个写者
+		for j := i - 1; j >= 0; j-- {
+			if strings.HasPrefix(nodes[j].name, "write-") {
+				return nodes[j].name, false
+			}
+		}
+		return "", true
+	})
+	endAcquire(&l.mu, &l.readNode, &l.reading, node)
+	return err
+}
+
+// RUnlock 释放读锁。
+func (l *RWLock) RUnlock() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return releaseSeq(l.store, &l.readNode)
+}
+
+// Lock 获取写锁，直到获取成功、出错或ctx结束。
+func (l *RWLock) Lock(ctx context.Context) error {
+	if !beginAcquire(&l.mu, &l.writeNode, &l.writing) {
+		return backends.ErrDeadlock
+	}
+	node, err := acquireSeq(ctx, l.store, l.path, "write-", true, func(nodes []seqNode, i int) (string, bool) {
+		if i == 0 {
+			return "", true
+		}
+		return nodes[i-1].name, false
+	})
+	endAcquire(&l.mu, &l.writeNode, &l.writing, node)
+	return err
+}
+
+// Unlock 释放写锁。
+func (l *RWLock) Unlock() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return releaseSeq(l.store, &l.writeNode)
+}
+
+// Semaphore 基于临时顺序节点实现的分布式计数信号量，整个集群内最多允许size个持有者同时持有许可。
+// 每个Semaphore实例最多持有一个许可，同一实例重复获取会返回ErrDeadlock，每个持有者应各自获取实例。
+type Semaphore struct {
+	store     backends.StoreClient
+	path      string
+	size      int
+	mu        sync.Mutex // 只保护以下字段，等待许可时不持有
+	node      string
+	acquiring bool // 正在获取许可
+}
+
+func newSemaphore(store backends.StoreClient, path string, size int) (*Semaphore, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("信号量[%s]的许可数必须大于0，实际:%d", path, size)
+	}
+	return &Semaphore{store: store, path: path, size: size}, nil
+}
+
+// Acquire 获取一个许可，直到获取成功、出错或ctx结束。
+func (s *Semaphore) Acquire(ctx context.Context) error {
+	_, err := s.acquire(ctx, true)
+	return err
+}
+
+// TryAcquire 尝试获取一个许可，当前没有空闲许可时立即返回false。
+func (s *Semaphore) TryAcquire() (bool, error) {
+	return s.acquire(context.Background(), false)
+}
+
+// Release 释放持有的许可。
+func (s *Semaphore) Release() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return releaseSeq(s.store, &s.node)
+}
+
+func (s *Semaphore) acquire(ctx context.Context, wait bool) (bool, error) {
+	if !beginAcquire(&s.mu, &s.node, &s.acquiring) {
+		return false, backends.ErrDeadlock
+	}
+	node, err := acquireSeq(ctx, s.store, s.path, "permit-", wait, func(nodes []seqNode, i int) (string, bool) {
+		// 任意一个持有者释放许可都可能让自己获得许可，因此监听整个子节点列表
+		return "", i < s.size
+	})
+	endAcquire(&s.mu, &s.node, &s.acquiring, node)
+	return err == nil && len(node) > 0, err
+}
+
+// beginAcquire 在mu保护下标记开始获取，已持有或正在获取时返回false；等待期间不持有mu，释放等操作不会被阻塞。
+func beginAcquire(mu *sync.Mutex, node *string, pending *bool) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	if len(*node) > 0 || *pending {
+		return false
+	}
+	*pending = true
+	return true
+}
+
+// endAcquire 在mu保护下记录获取到的节点(失败时为空)并清除获取中的标记。
+func endAcquire(mu *sync.Mutex, node *string, pending *bool, acquired string) {
+	mu.Lock()
+	defer mu.Unlock()
+	*node, *pending = acquired, false
+}
+
+type seqNode struct {
+	name string
+	seq  int64
+}
+
+// acquireSeq 在dir下创建带prefix前缀的临时顺序节点，并按顺序等待直到ready判断当前节点可以持有资源。
+// ready返回需要等待删除的节点名，为空且未就绪时则等待子节点列表发生变化；wait为false时未就绪则直接返回空节点名。
+// 失败或ctx结束时会删除已创建的节点。
+func acquireSeq(ctx context.Context, store backends.StoreClient, dir, prefix string, wait bool, ready func(nodes []seqNode, i int) (string, bool)) (string, error) {
+	if err := ensurePath(store, dir); err != nil {
+		return "", err
+	}
+	node, err := store.Add(dir+"/"+prefix, []byte{}, backends.FlagEphemeral|backends.FlagSequence)
+	if err != nil {
+		return "", err
+	}
+	name := node[strings.LastIndex(node, "/")+1:]
+	fail := func(err error) (string, error) {
+		store.Delete(node)
+		return "", err
+	}
+	for {
+		children, childCh, err := store.ChildrenW(dir)
+		if err != nil {
+			return fail(err)
+		}
+		nodes := sortSeq(children)
+		i := 0
+		for i < len(nodes) && nodes[i].name != name {
+			i++
+		}
+		if i == len(nodes) {
+			// 自己的节点已不存在(如会话过期)
+			return fail(backends.ErrLockLost)
+		}
+		waitOn, ok := ready(nodes, i)
+		if ok {
+			return node, nil
+		}
+		if !wait {
+			return fail(nil)
+		}
+		ch := childCh
+		if len(waitOn) > 0 {
+			exists, existCh, err := store.ExistsW(dir + "/" + waitOn)
+			if err != nil {
+				return fail(err)
+			}
+			if !exists {
+				continue
+			}
+			ch = existCh
+		}
+		select {
+		case e := <-ch:
+			if e.Err != nil {
+				return fail(e.Err)
+			}
+		case <-ctx.Done():
+			return fail(ctx.Err())
+		}
+	}
+}
+
+func releaseSeq(store backends.StoreClient, node *string) error {
+	if len(*node) == 0 {
+		return backends.ErrNotLocked
+	}
+	err := store.Delete(*node)
+	*node = ""
+	if err == backends.ErrNoNode {
+		return backends.ErrLockLost
+	}
+	return err
+}
+
+// sortSeq 按顺序号对子节点排序，忽略非顺序节点。
+func sortSeq(children []string) []seqNode {
+	nodes := make([]seqNode, 0, len(children))
+	for _, c := range children {
+		if seq, err := types.ParseSeq(c); err == nil {
+			nodes = append(nodes, seqNode{c, seq})
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].seq < nodes[j].seq })
+	return nodes
+}
+
+// ensurePath 逐级创建path及其所有父节点(已存在的节点忽略)。
+func ensurePath(store backends.StoreClient, path string) error {
+	p := ""
+	for _, part := range strings.Split(strings.Trim(path, "/"), "/") {
+		p += "/" + part
+		exists, err := store.Exists(p)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if _, err = store.Add(p, []byte{}, 0); err != nil && err != backends.ErrNodeExists {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package configuration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aluka-7/configuration/backends"
+)
+
+func TestRWLock(t *testing.T) {
+	conf := MockEngine(t, backends.StoreConfig{Exp: map[string]string{}})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	r1, r2, w := conf.RWLock("base", "job", "", "rw"), conf.RWLock("base", "job", "", "rw"), conf.RWLock("base", "job", "", "rw")
+	if err := r1.RLock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := r2.RLock(ctx); err != nil {
+		t.Fatal("多个读者应可同时持有读锁:", err)
+	}
+	wctx, wcancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer wcancel()
+	if err := w.Lock(wctx); err != context.DeadlineExceeded {
+		t.Error("存在读者时写锁应等待,实际:", err)
+	}
+	locked := make(chan error)
+	go func() { locked <- w.Lock(ctx) }()
+	r1.RUnlock()
+	r2.RUnlock()
+	if err := <-locked; err != nil {
+		t.Fatal("读者释放后应获得写锁:", err)
+	}
+	rctx, rcancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer rcancel()
+	if err := r1.RLock(rctx); err != context.DeadlineExceeded {
+		t.Error("存在写者时读锁应等待,实际:", err)
+	}
+	if err := w.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSemaphore(t *testing.T) {
+	conf := MockEngine(t, backends.StoreConfig{Exp: map[string]string{}})
+	if _, err := conf.Semaphore("base", "job", "", "workers", 0); err == nil {
+		t.Error("许可数<=0时应返回错误")
+	}
+	sems := make([]*Semaphore, 3)
+	for i := range sems {
+		s, err := conf.Semaphore("base", "job", "", "workers", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sems[i] = s
+	}
+	s1, s2, s3 := sems[0], sems[1], sems[2]
+	for _, s := range []*Semaphore{s1, s2} {
+		if ok, err := s.TryAcquire(); !ok || err != nil {
+			t.Fatal("许可未用完时应获取成功:", err)
+		}
+	}
+	if ok, _ := s3.TryAcquire(); ok {
+		t.Error("许可用完后不应获取成功")
+	}
+	acquired := make(chan error)
+	go func() { acquired <- s3.Acquire(context.Background()) }()
+	s1.Release()
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("有许可释放后应获取成功")
+	}
+}
+
+func TestRecipeReleaseWhileWaiting(t *testing.T) {
+	store, _ := backends.NewMock(backends.StoreConfig{})
+	sem, err := newSemaphore(store, "/system/base/job/workers", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	holder, _ := newSemaphore(store, "/system/base/job/workers", 1)
+	if ok, err := holder.TryAcquire(); !ok || err != nil {
+		t.Fatal("获取许可失败:", err)
+	}
+	rw, writer := &RWLock{store: store, path: "/system/base/job/rw"}, &RWLock{store: store, path: "/system/base/job/rw"}
+	if err = rw.Lock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sem.Acquire(ctx)
+	go writer.Lock(ctx)
+	for _, dir := range []string{"/system/base/job/workers", "/system/base/job/rw"} {
+		for i := 0; ; i++ {
+			if children, _ := store.Children(dir); len(children) == 2 {
+				break
+			} else if i == 100 {
+				t.Fatal("未开始等待:", dir)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	// 等待期间不持有实例的互斥锁，释放立即返回
+	released := make(chan error, 2)
+	go func() { released <- sem.Release() }()
+	go func() { released <- writer.Unlock() }()
+	for i := 0; i < 2; i++ {
+		select {
+		case err = <-released:
+			if err != backends.ErrNotLocked {
+				t.Error("等待期间释放应返回ErrNotLocked,实际:", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("等待期间释放被阻塞")
+		}
+	}
+	if _, err = sem.TryAcquire(); err != backends.ErrDeadlock {
+		t.Error("等待期间重复获取应返回ErrDeadlock,实际:", err)
+	}
+}
@@ -0,0 +1,111 @@
+package configuration
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aluka-7/configuration/backends"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	replyDir        = "_replies" // 应答目录
+	replyNodePrefix = "reply-"
+)
+
+// Reply 监听器对请求事件的应答。
+type Reply struct {
+	From      string                 `json:"from"`       // 应答方的实例标识，默认为主机名
+	Body      map[string]interface{} `json:"body"`       // 应答的数据
+	RepliedAt int64                  `json:"replied_at"` // 应答时间，单位毫秒
+}
+
+// Request 以请求/应答模式发布事件：事件带上唯一的CorrelationID和应答路径ReplyTo后发布，
+// 监听器通过Reply写入应答，返回在timeout内收到的所有应答(可能为空)。超时后应答目录会被删除，
+// 请求方在此之前崩溃时遗留的应答目录由StartJanitor启动的清理任务按RetentionPolicy.ReplyMaxAge删除，timeout应小于ReplyMaxAge。
+func (ee *eventEngine) Request(e *Event, timeout time.Duration) ([]Reply, error) {
+	if e == nil {
+		return nil, fmt.Errorf("发布的事件不能为nil")
+	}
+	id, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	e.CorrelationID = id
+	e.ReplyTo = ee.eventPath + "/" + replyDir + "/" + e.CorrelationID
+	if err := ensurePath(ee.store, e.ReplyTo); err != nil {
+		return nil, err
+	}
+	defer ee.deleteTree(e.ReplyTo)
+	deadline := time.After(timeout)
+	if err := ee.Publish(e); err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	replies := make([]Reply, 0)
+	for {
+		children, ch, err := ee.store.ChildrenW(e.ReplyTo)
+		if err != nil {
+			return replies, err
+		}
+		for _, n := range sortSeq(children) {
+			if seen[n.name] {
+				continue
+			}
+			seen[n.name] = true
+			b, _, err := ee.store.Get(e.ReplyTo + "/" + n.name)
+			if err != nil {
+				return replies, err
+			}
+			var r Reply
+			if err = json.Unmarshal(b, &r); err != nil {
+				log.Err(err).Msgf("解析事件[%s]的应答出错", e.Key)
+				continue
+			}
+			replies = append(replies, r)
+		}
+		select {
+		case <-ch:
+		case <-deadline:
+			return replies, nil
+		}
+	}
+}
+
+// ErrRequestGone 请求方已不再等待应答(Request已超时并删除了应答目录)时由Reply返回。
+var ErrRequestGone = errors.New("请求方已不再等待应答")
+
+// Reply 应答一个请求事件，事件不是通过Request发布时返回错误，请求已超时时返回ErrRequestGone。
+// 在订阅的处理函数中可忽略ErrRequestGone，避免该事件被重试并进入死信目录。
+func (ee *eventEngine) Reply(request Event, body map[string]interface{}) error {
+	if len(request.ReplyTo) == 0 {
+		return fmt.Errorf("事件[%s]不需要应答", request.Key)
+	}
+	if !strings.HasPrefix(request.ReplyTo, ee.eventPath+"/"+replyDir+"/") {
+		return fmt.Errorf("事件[%s]的应答路径[%s]不合法", request.Key, request.ReplyTo)
+	}
+	b, err := json.Marshal(Reply{From: ee.instance, Body: body, RepliedAt: time.Now().UnixMilli()})
+	if err != nil {
+		return err
+	}
+	_, err = ee.store.Add(request.ReplyTo+"/"+replyNodePrefix, b, backends.FlagSequence)
+	if err == backends.ErrNoNode {
+		return fmt.Errorf("事件[%s]的%w", request.Key, ErrRequestGone)
+	}
+	return err
+}
+
+// deleteTree 删除节点及其所有子节点。
+func (ee *eventEngine) deleteTree(path string) {
+	children, err := ee.store.Children(path)
+	if err != nil {
+		return
+	}
+	for _, c := range children {
+		ee.deleteTree(path + "/" + c)
+	}
+	ee.store.Delete(path)
+}
@@ -0,0 +1,191 @@
+package configuration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aluka-7/configuration/backends"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// SchemaNamespace JSON Schema的存储目录，app/group的schema存储在/system_schemas/<app>/<group>节点中。
+const SchemaNamespace = "/system_schemas"
+
+// SchemaError 配置数据不符合JSON Schema。
+type SchemaError struct {
+	Path   string   // 配置项的完整路径或文件名
+	Errors []string // 所有不满足的约束，格式为"实例位置: 说明"
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("配置项[%s]不符合JSON Schema:\n  %s", e.Path, strings.Join(e.Errors, "\n  "))
+}
+
+// ValidateSchema 校验配置数据是否符合schema，name为配置项路径或文件名，数据同Clazz按opts(如WithExtensionCodec)或内容特征解码，
+// 因此YAML/TOML等格式的数据也可以按JSON Schema校验。不符合时返回*SchemaError。
+func ValidateSchema(schema []byte, name string, data []byte, opts ...DecodeOption) error {
+	s, err := compileSchema(schema)
+	if err != nil {
+		return err
+	}
+	return validateSchema(s, name, data, opts, nil)
+}
+
+func compileSchema(schema []byte) (*jsonschema.Schema, error) {
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource("schema.json", bytes.NewReader(schema)); err != nil {
+		return nil, fmt.Errorf("解析JSON Schema出错:%w", err)
+	}
+	s, err := c.Compile("schema.json")
+	if err != nil {
+		return nil, fmt.Errorf("编译JSON Schema出错:%w", err)
+	}
+	return s, nil
+}
+
+// validateSchema 解码后按schema校验，types不为空时只校验JSON类型(见jsonType)在types中的数据，其他数据不校验。
+func validateSchema(s *jsonschema.Schema, name string, data []byte, opts []DecodeOption, types []string) error {
+	var v interface{}
+	if err := decode(name, data, &v, opts); err != nil {
+		return &SchemaError{Path: name, Errors: []string{err.Error()}}
+	}
+	// 统一为JSON的数据类型(如TOML的int64、YAML的map)
+	b, err := json.Marshal(v)
+	if err != nil {
+		return &SchemaError{Path: name, Errors: []string{err.Error()}}
+	}
+	if err = json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	if len(types) > 0 && !matchType(v, types) {
+		return nil
+	}
+	err = s.Validate(v)
+	ve, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return err
+	}
+	se := &SchemaError{Path: name}
+	for _, e := range ve.BasicOutput().Errors {
+		if len(e.Error) == 0 || strings.HasPrefix(e.Error, "doesn't validate with") {
+			// 只保留具体的约束，忽略汇总性的上层错误
+			continue
+		}
+		loc := e.InstanceLocation
+		if len(loc) == 0 {
+			loc = "/"
+		}
+		se.Errors = append(se.Errors, loc+": "+e.Error)
+	}
+	return se
+}
+
+func (configuration) schemaPath(app, group string) string {
+	return strings.Join([]string{SchemaNamespace, app, group}, "/")
+}
+
+// RegisterSchema 注册app/group下所有配置项的JSON Schema，之后通过Add/Modify写入的非空配置数据都会按schema校验。
+func (c configuration) RegisterSchema(app, group string, schema []byte) error {
+	if _, err := compileSchema(schema); err != nil {
+		return err
+	}
+	path := c.schemaPath(app, group)
+	err := c.store.Modify(path, schema)
+	if err == backends.ErrNoNode {
+		if err = ensurePath(c.store, SchemaNamespace+"/"+app); err == nil {
+			_, err = c.store.Add(path, schema, 0)
+		}
+	}
+	return err
+}
+
+// Schema 获取app/group注册的JSON Schema，未注册时返回的错误满足errors.Is(err, backends.ErrNoNode)。
+func (c configuration) Schema(app, group string) ([]byte, error) {
+	path := c.schemaPath(app, group)
+	b, _, err := c.store.Get(path)
+	if err == backends.ErrNoNode {
+		return nil, fmt.Errorf("[%s]未注册JSON Schema:%w", path, err)
+	}
+	return b, err
+}
+
+// DeleteSchema 删除app/group注册的JSON Schema。
+func (c configuration) DeleteSchema(app, group string) error {
+	return c.store.Delete(c.schemaPath(app, group))
+}
+
+// checkSchema 写入配置前按app/group注册的schema校验，未注册schema或数据为空(如目录节点)时不校验。
+// schema作用于分组下的所有配置项，但只校验与schema顶层type一致的配置数据：如type为object的schema
+// 不校验同一分组下timeout=3s这样的标量配置项；schema未声明顶层type时校验所有配置项。
+func (c configuration) checkSchema(app, group, path string, value []byte) error {
+	if len(value) == 0 {
+		return nil
+	}
+	schema, _, err := c.store.Get(c.schemaPath(app, group))
+	if err == backends.ErrNoNode {
+		return nil
+	}
+	if err != nil && len(c.snapshot) > 0 {
+		// 配置中心不可用时写操作本身也会失败
+		return readOnly(err)
+	}
+	if err != nil {
+		return err
+	}
+	s, err := compileSchema(schema)
+	if err != nil {
+		return err
+	}
+	return validateSchema(s, path, value, nil, schemaTypes(schema))
+}
+
+// schemaTypes schema顶层声明的type，未声明时为空。
+func schemaTypes(schema []byte) []string {
+	var s struct {
+		Type interface{} `json:"type"`
+	}
+	json.Unmarshal(schema, &s)
+	switch t := s.Type.(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		types := make([]string, 0, len(t))
+		for _, v := range t {
+			if name, ok := v.(string); ok {
+				types = append(types, name)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+// matchType 判断JSON数据的类型是否为types之一，整数同时满足integer和number。
+func matchType(v interface{}, types []string) bool {
+	var actual string
+	switch n := v.(type) {
+	case map[string]interface{}:
+		actual = "object"
+	case []interface{}:
+		actual = "array"
+	case string:
+		actual = "string"
+	case bool:
+		actual = "boolean"
+	case float64:
+		actual = "number"
+		if n == float64(int64(n)) {
+			actual = "integer"
+		}
+	case nil:
+		actual = "null"
+	}
+	for _, t := range types {
+		if t == actual || t == "number" && actual == "integer" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,362 @@
+package configuration
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aluka-7/configuration/backends"
+	"github.com/rs/zerolog/log"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// reconnectInterval 启动时连接配置中心失败后重试连接的间隔。
+const reconnectInterval = time.Second * 5
+
+// ErrReadOnly 配置中心不可用时处于只读的降级模式，写操作返回的错误满足errors.Is(err, ErrReadOnly)。
+var ErrReadOnly = errors.New("配置中心不可用，当前为只读的降级模式")
+
+// WithSnapshot 开启本地快照：成功读取的配置项以AES-GCM加密保存到本地文件file中，key为调用方提供的16、24或32字节的密钥，
+// 长度不符时创建引擎会panic。密钥不正确或文件被篡改时不使用快照中的数据。
+// 启动时连接配置中心失败不再panic，配置中心不可用时从快照读取配置并进入只读的降级模式，同时在后台重试连接，
+// 降级期间Stale返回true，直到再次成功从配置中心读取。
+func WithSnapshot(file string, key []byte) Option {
+	return func(c *configuration) {
+		c.snapshot = file
+		c.snapshotKey = key
+	}
+}
+
+// Stale 当前的配置是否来自本地快照(配置中心不可用的降级模式)，未开启本地快照时总是false。
+func (c configuration) Stale() bool {
+	store := c.store
+	if cs, ok := store.(*cacheStore); ok {
+		store = cs.StoreClient
+	}
+	if s, ok := store.(*snapshotStore); ok {
+		return s.stale.Load()
+	}
+	return false
+}
+
+// snapshotFile 本地快照文件加密前的内容。
+type snapshotFile struct {
+	SavedAt int64             `json:"saved_at"` // 保存时间，单位毫秒
+	Values  map[string]string `json:"values"`   // 配置项的完整路径和配置数据
+}
+
+// snapshotStore 在配置中心之上维护本地快照的StoreClient，配置中心不可用时GetValues从快照读取，写操作返回ErrReadOnly。
+type snapshotStore struct {
+	file     string
+	aead     cipher.AEAD
+	connect  func() (backends.StoreClient, error)
+	mu       sync.RWMutex
+	backend  backends.StoreClient // 尚未连接成功时为nil
+	values   map[string]string
+	stale    atomic.Bool
+	stopChan chan bool
+	stopOnce sync.Once
+}
+
+func newSnapshotStore(file string, key []byte, connect func() (backends.StoreClient, error)) (*snapshotStore, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("本地快照的密钥不正确:%w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	s := &snapshotStore{file: file, aead: aead, connect: connect, values: make(map[string]string), stopChan: make(chan bool)}
+	if err := s.load(); err != nil && !os.IsNotExist(err) {
+		log.Err(err).Msgf("读取本地快照[%s]出错:%+v", file, err)
+	}
+	backend, err := connect()
+	if err != nil {
+		log.Err(err).Msgf("连接配置中心出错，使用本地快照[%s]并在后台重试连接:%+v", file, err)
+		s.stale.Store(true)
+		go s.reconnect()
+	} else {
+		s.backend = backend
+	}
+	return s, nil
+}
+
+// reconnect 每隔reconnectInterval重试连接配置中心，直到连接成功或Close。
+func (s *snapshotStore) reconnect() {
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case <-time.After(reconnectInterval):
+		}
+		backend, err := s.connect()
+		if err != nil {
+			log.Err(err).Msgf("重试连接配置中心出错:%+v", err)
+			continue
+		}
+		s.mu.Lock()
+		s.backend = backend
+		s.mu.Unlock()
+		log.Info().Msg("重新连接配置中心成功")
+		return
+	}
+}
+
+// store 返回已连接的配置中心，尚未连接时返回zk.ErrNoServer，连接前已Close时返回zk.ErrClosing。
+func (s *snapshotStore) store() (backends.StoreClient, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.backend == nil {
+		select {
+		case <-s.stopChan:
+			return nil, zk.ErrClosing
+		default:
+		}
+		return nil, zk.ErrNoServer
+	}
+	return s.backend, nil
+}
+
+// unavailable 判断错误是否由配置中心不可用导致。
+func unavailable(err error) bool {
+	for _, e := range []error{zk.ErrNoServer, zk.ErrConnectionClosed, zk.ErrSessionExpired, zk.ErrClosing} {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetValues 从配置中心读取并更新快照，配置中心不可用时返回快照中存在的配置项，快照中一个都没有时返回原来的错误。
+func (s *snapshotStore) GetValues(keys []string) (map[string]string, error) {
+	backend, err := s.store()
+	if err == nil {
+		var vl map[string]string
+		if vl, err = backend.GetValues(keys); err == nil {
+			s.stale.Store(false)
+			s.update(keys, vl)
+			return vl, nil
+		}
+	}
+	if !unavailable(err) {
+		return nil, err
+	}
+	s.mu.RLock()
+	vl := make(map[string]string, len(keys))
+	for _, k := range keys {
+		if v, ok := s.values[k]; ok {
+			vl[k] = v
+		}
+	}
+	s.mu.RUnlock()
+	if len(vl) == 0 {
+		return nil, err
+	}
+	s.stale.Store(true)
+	log.Warn().Msgf("配置中心不可用，从本地快照读取配置项%v:%+v", keys, err)
+	return vl, nil
+}
+
+// update 用最新读取的配置更新快照，keys中不存在的配置项从快照中删除，有变化时保存快照文件。
+func (s *snapshotStore) update(keys []string, vl map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := false
+	for _, k := range keys {
+		old, existed := s.values[k]
+		v, ok := vl[k]
+		switch {
+		case ok && (!existed || old != v):
+			s.values[k] = v
+			changed = true
+		case !ok && existed:
+			delete(s.values, k)
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	if err := s.save(); err != nil {
+		log.Err(err).Msgf("保存本地快照[%s]出错:%+v", s.file, err)
+	}
+}
+
+// load 读取并解密快照文件，文件格式为base64(nonce+密文)，解密失败(密钥不正确或被篡改)时返回错误。
+func (s *snapshotStore) load() error {
+	b, err := os.ReadFile(s.file)
+	if err != nil {
+		return err
+	}
+	ds, err := base64.URLEncoding.DecodeString(string(b))
+	if err != nil {
+		return err
+	}
+	n := s.aead.NonceSize()
+	if len(ds) < n {
+		return fmt.Errorf("本地快照[%s]已损坏", s.file)
+	}
+	data, err := s.aead.Open(nil, ds[:n], ds[n:], nil)
+	if err != nil {
+		return fmt.Errorf("解密本地快照[%s]出错(密钥不正确或文件被篡改):%w", s.file, err)
+	}
+	var f snapshotFile
+	if err = json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+	if f.Values != nil {
+		s.values = f.Values
+	}
+	log.Info().Msgf("读取本地快照[%s]，保存于%s", s.file, time.UnixMilli(f.SavedAt).Format(time.RFC3339))
+	return nil
+}
+
+// save 加密并原子地写入快照文件，调用方需持有s.mu。
+func (s *snapshotStore) save() error {
+	data, err := json.Marshal(snapshotFile{SavedAt: time.Now().UnixMilli(), Values: s.values})
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return err
+	}
+	ds := s.aead.Seal(nonce, nonce, data, nil)
+	tmp, err := os.CreateTemp(filepath.Dir(s.file), filepath.Base(s.file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.WriteString(base64.URLEncoding.EncodeToString(ds)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.file)
+}
+
+// readOnly 写操作因配置中心不可用失败时包装为ErrReadOnly。
+func readOnly(err error) error {
+	if err != nil && unavailable(err) {
+		return fmt.Errorf("%w:%v", ErrReadOnly, err)
+	}
+	return err
+}
+
+func (s *snapshotStore) Client() *zk.Conn {
+	if backend, err := s.store(); err == nil {
+		return backend.Client()
+	}
+	return nil
+}
+
+func (s *snapshotStore) Get(path string) ([]byte, *backends.Stat, error) {
+	backend, err := s.store()
+	if err != nil {
+		return nil, nil, err
+	}
+	return backend.Get(path)
+}
+
+func (s *snapshotStore) WatchPrefix(keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	backend, err := s.store()
+	if err != nil {
+		return waitIndex, err
+	}
+	return backend.WatchPrefix(keys, waitIndex, stopChan)
+}
+
+func (s *snapshotStore) Lock(path string) backends.Locker {
+	if backend, err := s.store(); err == nil {
+		return backend.Lock(path)
+	}
+	return offlineLock{}
+}
+
+func (s *snapshotStore) Add(path string, value []byte, flags int32) (string, error) {
+	backend, err := s.store()
+	if err != nil {
+		return "", readOnly(err)
+	}
+	p, err := backend.Add(path, value, flags)
+	return p, readOnly(err)
+}
+
+func (s *snapshotStore) Modify(path string, value []byte) error {
+	backend, err := s.store()
+	if err != nil {
+		return readOnly(err)
+	}
+	return readOnly(backend.Modify(path, value))
+}
+
+func (s *snapshotStore) Delete(path string) error {
+	backend, err := s.store()
+	if err != nil {
+		return readOnly(err)
+	}
+	return readOnly(backend.Delete(path))
+}
+
+func (s *snapshotStore) Exists(path string) (bool, error) {
+	backend, err := s.store()
+	if err != nil {
+		return false, err
+	}
+	return backend.Exists(path)
+}
+
+func (s *snapshotStore) ExistsW(path string) (bool, <-chan backends.Event, error) {
+	backend, err := s.store()
+	if err != nil {
+		return false, nil, err
+	}
+	return backend.ExistsW(path)
+}
+
+func (s *snapshotStore) Children(path string) ([]string, error) {
+	backend, err := s.store()
+	if err != nil {
+		return nil, err
+	}
+	return backend.Children(path)
+}
+
+func (s *snapshotStore) ChildrenW(path string) ([]string, <-chan backends.Event, error) {
+	backend, err := s.store()
+	if err != nil {
+		return nil, nil, err
+	}
+	return backend.ChildrenW(path)
+}
+
+func (s *snapshotStore) Close() {
+	s.stopOnce.Do(func() {
+		close(s.stopChan)
+	})
+	if backend, err := s.store(); err == nil {
+		backend.Close()
+	}
+}
+
+// offlineLock 尚未连接配置中心时返回的锁，所有操作都返回zk.ErrNoServer。
+type offlineLock struct{}
+
+func (offlineLock) Lock() error                       { return zk.ErrNoServer }
+func (offlineLock) LockContext(context.Context) error { return zk.ErrNoServer }
+func (offlineLock) TryLock() (bool, error)            { return false, zk.ErrNoServer }
+func (offlineLock) Unlock() error                     { return backends.ErrNotLocked }
+func (offlineLock) Lost() <-chan struct{}             { return nil }
@@ -0,0 +1,49 @@
+package configuration
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+)
+
+type traceParentKey struct{}
+
+// traceParentPattern W3C Trace Context的traceparent格式: version-trace_id-parent_id-flags。
+var traceParentPattern = regexp.MustCompile("^[0-9a-f]{2}-[0-9a-f]{32}-[0-9a-f]{16}-[0-9a-f]{2}$")
+
+// ContextWithTraceParent 返回携带W3C traceparent的ctx，以该ctx发布的事件会带上这个traceparent。
+func ContextWithTraceParent(ctx context.Context, traceParent string) context.Context {
+	return context.WithValue(ctx, traceParentKey{}, traceParent)
+}
+
+// TraceParentFromContext 获取ctx携带的W3C traceparent，不存在或格式不正确时返回空字符串。
+// 事件处理函数的ctx携带了事件发布时的traceparent。
+func TraceParentFromContext(ctx context.Context) string {
+	if tp, ok := ctx.Value(traceParentKey{}).(string); ok && traceParentPattern.MatchString(tp) {
+		return tp
+	}
+	return ""
+}
+
+// newTraceParent 开启一个新的trace，生成随机的trace_id和parent_id并标记为采样。
+func newTraceParent() (string, error) {
+	traceID, err := randomHex(16)
+	if err != nil {
+		return "", err
+	}
+	spanID, err := randomHex(8)
+	if err != nil {
+		return "", err
+	}
+	return "00-" + traceID + "-" + spanID + "-01", nil
+}
+
+// randomHex 生成n字节随机数的十六进制字符串。
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
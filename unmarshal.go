@@ -0,0 +1,142 @@
+package configuration
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// boundField Unmarshal中与配置项绑定的结构体字段。
+type boundField struct {
+	value      reflect.Value
+	path       string
+	def        string
+	hasDefault bool
+}
+
+// Unmarshal 按结构体字段的config标签(相对于app/group/tag的配置项路径)一次批量读取多个配置项并填充到v指向的结构体中，
+// 如`config:"db/host" default:"localhost"`，配置项不存在时使用default标签的值，都没有时保留字段原值。
+// 结构体类型的字段如果其字段带有config标签，则该字段的config标签作为路径前缀(可省略)继续绑定其字段，
+// 否则整个配置项按Clazz的规则解码到该字段。字段支持基本类型、切片、map、time.Duration和encoding.TextUnmarshaler，
+// 切片和map的配置数据可以是JSON或以逗号分隔的列表。填充后同Clazz进行校验，出错或校验失败时v(包括嵌套的字段)保持原值。
+func (c configuration) Unmarshal(app, group, tag string, v interface{}, opts ...DecodeOption) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("Unmarshal的目标必须是非nil的结构体指针:%T", v)
+	}
+	// 在副本上填充，collectFields拷贝带有config标签的嵌套结构体指针，出错或校验失败时v(包括这些嵌套的结构体)保持原值，
+	// 其他字段(如未加标签的指针)与v共享，不会被替换
+	tmp := reflect.New(rv.Elem().Type())
+	tmp.Elem().Set(rv.Elem())
+	fields := make([]boundField, 0)
+	collectFields(tmp.Elem(), "", make(map[reflect.Type]bool), &fields)
+	paths := make([]string, len(fields))
+	for i, f := range fields {
+		paths[i] = f.path
+	}
+	vl, err := c.Resolve(app, group, tag, paths)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		path := c.maskPath(app, group, tag, f.path)
+		r, ok := vl[path]
+		if !ok {
+			if !f.hasDefault {
+				continue
+			}
+			r.Value = f.def
+		} else {
+			path = r.Source
+		}
+		if err = setField(f.value, path, r.Value); err != nil {
+			return err
+		}
+	}
+	if err = validateValue(strings.TrimSuffix(c.maskPath(app, group, tag, ""), "/"), tmp.Interface(), opts); err != nil {
+		return err
+	}
+	rv.Elem().Set(tmp.Elem())
+	return nil
+}
+
+// collectFields 收集v中带有config标签的字段，prefix为上级结构体字段的路径前缀。嵌套的结构体指针替换为其副本(nil时新建)，
+// 填充不会写入原来的结构体；stack记录正在收集的结构体类型，自引用的类型不再继续展开。
+func collectFields(v reflect.Value, prefix string, stack map[reflect.Type]bool, fields *[]boundField) {
+	t := v.Type()
+	stack[t] = true
+	defer delete(stack, t)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, ok := f.Tag.Lookup("config")
+		if !f.IsExported() || tag == "-" {
+			continue
+		}
+		fv := v.Field(i)
+		if nested(f.Type) {
+			if fv.Kind() == reflect.Ptr {
+				if stack[f.Type.Elem()] {
+					continue
+				}
+				cp := reflect.New(f.Type.Elem())
+				if !fv.IsNil() {
+					cp.Elem().Set(fv.Elem())
+				}
+				fv.Set(cp)
+				fv = fv.Elem()
+			}
+			collectFields(fv, joinPath(prefix, tag), stack, fields)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		def, hasDefault := f.Tag.Lookup("default")
+		*fields = append(*fields, boundField{value: fv, path: joinPath(prefix, tag), def: def, hasDefault: hasDefault})
+	}
+}
+
+// nested 判断字段是否为需要继续按config标签绑定的结构体(或结构体指针)。
+func nested(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if _, ok := t.Field(i).Tag.Lookup("config"); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func joinPath(prefix, path string) string {
+	switch {
+	case len(prefix) == 0:
+		return path
+	case len(path) == 0:
+		return prefix
+	default:
+		return strings.TrimSuffix(prefix, "/") + "/" + strings.TrimPrefix(path, "/")
+	}
+}
+
+// setField 将配置数据赋值给字段，结构体以及JSON格式的切片/map按Clazz的规则解码。
+func setField(v reflect.Value, path, raw string) error {
+	t := v.Type()
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	trimmed := strings.TrimSpace(raw)
+	structured := t.Kind() == reflect.Struct && t != durationType && !reflect.PtrTo(t).Implements(textUnmarshalerType) ||
+		(t.Kind() == reflect.Slice || t.Kind() == reflect.Map) && (strings.HasPrefix(trimmed, "[") || strings.HasPrefix(trimmed, "{"))
+	if structured {
+		return decode(path, []byte(raw), v.Addr().Interface(), nil)
+	}
+	if err := setString(v, trimmed); err != nil {
+		return fmt.Errorf("配置项[%s]的值[%s]不能转换为%s:%w", path, raw, v.Type(), err)
+	}
+	return nil
+}
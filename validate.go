@@ -0,0 +1,346 @@
+package configuration
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Validator 可由配置结构体实现的校验接口，Clazz、Unmarshal和Bind在解码后调用，返回错误时拒绝该配置。
+type Validator interface {
+	Validate() error
+}
+
+// WithValidator 添加解码后的校验函数，在validate标签规则和Validator接口之后执行，返回错误时拒绝该配置。
+func WithValidator(fn func(v interface{}) error) DecodeOption {
+	return func(o *decodeOptions) {
+		o.validators = append(o.validators, fn)
+	}
+}
+
+// ValidationError 字段不满足validate标签中的规则。
+type ValidationError struct {
+	Field string      // 字段路径，如DB.Port
+	Rule  string      // 不满足的规则，如min=1
+	Value interface{} // 字段的值
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("字段[%s]的值[%v]不满足规则[%s]", e.Field, e.Value, e.Rule)
+}
+
+// ValidationErrors 所有不满足规则的字段。
+type ValidationErrors []*ValidationError
+
+func (es ValidationErrors) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, ";")
+}
+
+// Validate 按validate标签校验结构体(指针)的字段，之后若v实现了Validator则调用其Validate。
+// 标签规则以逗号分隔：required(非零值，切片/map非空)、min=n、max=n(数值比较大小，字符串/切片/map比较长度，
+// time.Duration可写作min=1s)、oneof=a b c(以空格分隔的可选值)、regex=表达式(必须是最后一个规则，表达式可包含逗号)。
+// 嵌套的结构体(指针)会递归校验。
+func Validate(v interface{}) error {
+	var errs ValidationErrors
+	if err := validateStruct(reflect.ValueOf(v), "", make(map[pointerKey]bool), &errs); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	if vv, ok := v.(Validator); ok {
+		return vv.Validate()
+	}
+	return nil
+}
+
+// validateValue 校验解码后的配置，错误中包含配置项的完整路径。
+func validateValue(path string, v interface{}, opts []DecodeOption) error {
+	err := Validate(v)
+	if err == nil {
+		for _, fn := range newDecodeOptions(opts).validators {
+			if err = fn(v); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("配置项[%s]校验失败:%w", path, err)
+	}
+	return nil
+}
+
+// decodeValidated 解码并校验配置，解码到v的深拷贝上，通过校验后才写入v，因此校验失败时v(包括其引用的指针、切片和map)保持原值。
+func decodeValidated(path string, data []byte, v interface{}, opts []DecodeOption) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return decode(path, data, v, opts)
+	}
+	tmp := reflect.New(rv.Elem().Type())
+	tmp.Elem().Set(deepCopy(rv.Elem()))
+	if err := decode(path, data, tmp.Interface(), opts); err != nil {
+		return err
+	}
+	if err := validateValue(path, tmp.Interface(), opts); err != nil {
+		return err
+	}
+	rv.Elem().Set(tmp.Elem())
+	return nil
+}
+
+// deepCopy 深拷贝v中解码器可能写入的部分：结构体只深拷贝带有config、json、yaml或toml标签的导出字段，
+// 其他字段(如未加标签的*sql.DB、含锁的结构体指针)与v共享；已拷贝的指针按地址复用，循环引用不会无限递归。
+func deepCopy(v reflect.Value) reflect.Value {
+	return copyValue(v, make(map[pointerKey]reflect.Value))
+}
+
+// pointerKey 指针的类型和地址，结构体与其第一个字段的地址相同，因此需要同时区分类型。
+type pointerKey struct {
+	t reflect.Type
+	p uintptr
+}
+
+func copyValue(v reflect.Value, copied map[pointerKey]reflect.Value) reflect.Value {
+	out := reflect.New(v.Type()).Elem()
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			break
+		}
+		k := pointerKey{v.Type(), v.Pointer()}
+		if p, ok := copied[k]; ok {
+			out.Set(p)
+			break
+		}
+		p := reflect.New(v.Type().Elem())
+		copied[k] = p
+		p.Elem().Set(copyValue(v.Elem(), copied))
+		out.Set(p)
+	case reflect.Interface:
+		if !v.IsNil() {
+			out.Set(copyValue(v.Elem(), copied))
+		}
+	case reflect.Struct:
+		out.Set(v)
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if decodable(t.Field(i)) {
+				out.Field(i).Set(copyValue(v.Field(i), copied))
+			}
+		}
+	case reflect.Slice:
+		if !v.IsNil() {
+			out.Set(reflect.MakeSlice(v.Type(), v.Len(), v.Len()))
+			for i := 0; i < v.Len(); i++ {
+				out.Index(i).Set(copyValue(v.Index(i), copied))
+			}
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			out.Index(i).Set(copyValue(v.Index(i), copied))
+		}
+	case reflect.Map:
+		if !v.IsNil() {
+			out.Set(reflect.MakeMapWithSize(v.Type(), v.Len()))
+			iter := v.MapRange()
+			for iter.Next() {
+				out.SetMapIndex(iter.Key(), copyValue(iter.Value(), copied))
+			}
+		}
+	default:
+		out.Set(v)
+	}
+	return out
+}
+
+// decodable 判断结构体字段是否带有解码器使用的标签(config、json、yaml或toml，且不为"-")。
+func decodable(f reflect.StructField) bool {
+	if !f.IsExported() {
+		return false
+	}
+	for _, key := range []string{"config", "json", "yaml", "toml"} {
+		if tag, ok := f.Tag.Lookup(key); ok && strings.Split(tag, ",")[0] != "-" {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidatedListener 包装Get使用的监听器，变化后的数据校验失败时不通知listener(保留上一次的配置)，并通过onReject报告。
+func ValidatedListener(listener ChangedListener, validate func(data map[string]string) error, onReject func(err error)) ChangedListener {
+	return &validatedListener{listener: listener, validate: validate, onReject: onReject}
+}
+
+type validatedListener struct {
+	listener ChangedListener
+	validate func(data map[string]string) error
+	onReject func(err error)
+}
+
+func (l *validatedListener) Changed(data map[string]string) {
+	if err := l.validate(data); err != nil {
+		log.Err(err).Msgf("配置%v校验失败，保留上一次的配置", keys(data))
+		if l.onReject != nil {
+			l.onReject(err)
+		}
+		return
+	}
+	l.listener.Changed(data)
+}
+
+func keys(data map[string]string) []string {
+	ks := make([]string, 0, len(data))
+	for k := range data {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+// validateStruct 递归校验结构体的字段，visited记录已校验的指针，循环引用的结构体只校验一次。
+func validateStruct(v reflect.Value, prefix string, visited map[pointerKey]bool, errs *ValidationErrors) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		if v.Kind() == reflect.Ptr {
+			k := pointerKey{v.Type(), v.Pointer()}
+			if visited[k] {
+				return nil
+			}
+			visited[k] = true
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := prefix + f.Name
+		if rules, ok := f.Tag.Lookup("validate"); ok {
+			if err := checkRules(v.Field(i), name, rules, errs); err != nil {
+				return err
+			}
+		}
+		if ft := f.Type; ft.Kind() == reflect.Struct || ft.Kind() == reflect.Ptr && ft.Elem().Kind() == reflect.Struct {
+			if err := validateStruct(v.Field(i), name+".", visited, errs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkRules 校验一个字段，规则本身写错时返回错误，不满足规则时记录到errs。
+func checkRules(v reflect.Value, name, rules string, errs *ValidationErrors) error {
+	for len(rules) > 0 {
+		var rule string
+		if strings.HasPrefix(rules, "regex=") {
+			rule, rules = rules, ""
+		} else {
+			rule, rules, _ = strings.Cut(rules, ",")
+		}
+		op, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		if op == "required" && v.IsZero() {
+			*errs = append(*errs, &ValidationError{Field: name, Rule: rule, Value: v.Interface()})
+			continue
+		}
+		fv := v
+		for fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				break
+			}
+			fv = fv.Elem()
+		}
+		if fv.Kind() == reflect.Ptr {
+			// nil指针只校验required
+			continue
+		}
+		ok, err := checkRule(fv, op, param)
+		if err != nil {
+			return fmt.Errorf("字段[%s]的校验规则[%s]不正确:%w", name, rule, err)
+		}
+		if !ok {
+			*errs = append(*errs, &ValidationError{Field: name, Rule: rule, Value: fv.Interface()})
+		}
+	}
+	return nil
+}
+
+func checkRule(v reflect.Value, op, param string) (bool, error) {
+	switch op {
+	case "required":
+		return v.Kind() != reflect.Slice && v.Kind() != reflect.Map || v.Len() > 0, nil
+	case "min", "max":
+		n, limit, err := ruleNumbers(v, param)
+		if err != nil {
+			return false, err
+		}
+		if op == "min" {
+			return n >= limit, nil
+		}
+		return n <= limit, nil
+	case "oneof":
+		s := fmt.Sprint(v.Interface())
+		for _, opt := range strings.Fields(param) {
+			if s == opt {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "regex":
+		re, err := regexp.Compile(param)
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(fmt.Sprint(v.Interface())), nil
+	default:
+		return false, fmt.Errorf("未知的规则[%s]", op)
+	}
+}
+
+// ruleNumbers 返回min/max比较的字段值和限制值。
+func ruleNumbers(v reflect.Value, param string) (float64, float64, error) {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(param)
+		if err != nil {
+			if n, er := strconv.ParseInt(param, 10, 64); er == nil {
+				d, err = time.Duration(n), nil
+			}
+		}
+		return float64(v.Int()), float64(d), err
+	}
+	limit, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), limit, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), limit, nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), limit, nil
+	case reflect.String:
+		return float64(len([]rune(v.String()))), limit, nil
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(v.Len()), limit, nil
+	default:
+		return 0, 0, fmt.Errorf("类型%s不支持min/max", v.Type())
+	}
+}
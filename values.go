@@ -0,0 +1,155 @@
+package configuration
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aluka-7/configuration/backends"
+	"github.com/rs/zerolog/log"
+)
+
+// Int 获取指定配置项并解析为int。
+func (c configuration) Int(app, group, tag, path string) (int, error) {
+	return parseValue(c, app, group, tag, path, "整数", strconv.Atoi)
+}
+
+// IntDefault 获取指定配置项并解析为int，配置项不存在或解析失败时返回def。
+func (c configuration) IntDefault(app, group, tag, path string, def int) int {
+	v, err := c.Int(app, group, tag, path)
+	return orDefault(v, err, def)
+}
+
+// Int64 获取指定配置项并解析为int64。
+func (c configuration) Int64(app, group, tag, path string) (int64, error) {
+	return parseValue(c, app, group, tag, path, "整数", func(s string) (int64, error) {
+		return strconv.ParseInt(s, 10, 64)
+	})
+}
+
+// Int64Default 获取指定配置项并解析为int64，配置项不存在或解析失败时返回def。
+func (c configuration) Int64Default(app, group, tag, path string, def int64) int64 {
+	v, err := c.Int64(app, group, tag, path)
+	return orDefault(v, err, def)
+}
+
+// Float64 获取指定配置项并解析为float64。
+func (c configuration) Float64(app, group, tag, path string) (float64, error) {
+	return parseValue(c, app, group, tag, path, "浮点数", func(s string) (float64, error) {
+		return strconv.ParseFloat(s, 64)
+	})
+}
+
+// Float64Default 获取指定配置项并解析为float64，配置项不存在或解析失败时返回def。
+func (c configuration) Float64Default(app, group, tag, path string, def float64) float64 {
+	v, err := c.Float64(app, group, tag, path)
+	return orDefault(v, err, def)
+}
+
+// Bool 获取指定配置项并解析为bool，支持1/t/true/0/f/false等写法(不区分大小写)。
+func (c configuration) Bool(app, group, tag, path string) (bool, error) {
+	return parseValue(c, app, group, tag, path, "布尔值", strconv.ParseBool)
+}
+
+// BoolDefault 获取指定配置项并解析为bool，配置项不存在或解析失败时返回def。
+func (c configuration) BoolDefault(app, group, tag, path string, def bool) bool {
+	v, err := c.Bool(app, group, tag, path)
+	return orDefault(v, err, def)
+}
+
+// Duration 获取指定配置项并解析为time.Duration，格式同time.ParseDuration，如"300ms"、"1h30m"。
+func (c configuration) Duration(app, group, tag, path string) (time.Duration, error) {
+	return parseValue(c, app, group, tag, path, "时长", time.ParseDuration)
+}
+
+// DurationDefault 获取指定配置项并解析为time.Duration，配置项不存在或解析失败时返回def。
+func (c configuration) DurationDefault(app, group, tag, path string, def time.Duration) time.Duration {
+	v, err := c.Duration(app, group, tag, path)
+	return orDefault(v, err, def)
+}
+
+// StringSlice 获取指定配置项并解析为字符串列表，配置数据可以是JSON数组或以逗号分隔的列表(各项会去除首尾空白)。
+func (c configuration) StringSlice(app, group, tag, path string) ([]string, error) {
+	return parseValue(c, app, group, tag, path, "字符串列表", parseStringSlice)
+}
+
+// StringSliceDefault 获取指定配置项并解析为字符串列表，配置项不存在或解析失败时返回def。
+func (c configuration) StringSliceDefault(app, group, tag, path string, def []string) []string {
+	v, err := c.StringSlice(app, group, tag, path)
+	return orDefault(v, err, def)
+}
+
+// StringMap 获取指定配置项并解析为字符串map，配置数据可以是JSON对象或以逗号分隔的key=value列表。
+func (c configuration) StringMap(app, group, tag, path string) (map[string]string, error) {
+	return parseValue(c, app, group, tag, path, "字符串map", parseStringMap)
+}
+
+// StringMapDefault 获取指定配置项并解析为字符串map，配置项不存在或解析失败时返回def。
+func (c configuration) StringMapDefault(app, group, tag, path string, def map[string]string) map[string]string {
+	v, err := c.StringMap(app, group, tag, path)
+	return orDefault(v, err, def)
+}
+
+// parseValue 获取单个配置项并以parse解析去除首尾空白后的配置数据，解析失败的错误中包含配置项的完整路径。
+func parseValue[T any](c configuration, app, group, tag, path, kind string, parse func(string) (T, error)) (T, error) {
+	path, v, err := c.value(app, group, tag, path)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	r, err := parse(strings.TrimSpace(v))
+	if err != nil {
+		return r, fmt.Errorf("配置项[%s]的值[%s]不是有效的%s:%w", path, v, kind, err)
+	}
+	return r, nil
+}
+
+// orDefault 出错时返回def，配置项存在但解析失败时记录日志。
+func orDefault[T any](v T, err error, def T) T {
+	if err != nil {
+		if !errors.Is(err, backends.ErrNoNode) {
+			log.Err(err).Msg("解析配置项出错，使用默认值")
+		}
+		return def
+	}
+	return v
+}
+
+func parseStringSlice(s string) ([]string, error) {
+	if strings.HasPrefix(s, "[") {
+		var r []string
+		err := json.Unmarshal([]byte(s), &r)
+		return r, err
+	}
+	r := make([]string, 0)
+	if len(s) == 0 {
+		return r, nil
+	}
+	for _, v := range strings.Split(s, ",") {
+		r = append(r, strings.TrimSpace(v))
+	}
+	return r, nil
+}
+
+func parseStringMap(s string) (map[string]string, error) {
+	if strings.HasPrefix(s, "{") {
+		var r map[string]string
+		err := json.Unmarshal([]byte(s), &r)
+		return r, err
+	}
+	r := make(map[string]string)
+	if len(s) == 0 {
+		return r, nil
+	}
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("[%s]不是key=value格式", strings.TrimSpace(kv))
+		}
+		r[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return r, nil
+}